@@ -0,0 +1,135 @@
+/*
+Migrate shows and changes the schema version of the database of the web API. The web API applies the pending
+migrations itself when it starts; this tool is for checking a database, applying migrations ahead of a deployment, or
+rolling them back before running an older build.
+
+Usage:
+
+	migrate [flags] <command> [version]
+
+The commands are:
+
+	status
+		List the migrations, telling which ones are applied.
+	up [version]
+		Apply the migrations up to the version, or all of them.
+	down [version]
+		Roll back the migrations down to the version, or the last one applied. Some migrations cannot be rolled back:
+		down refuses to go below the newest of them, before rolling back anything. status tells how low it can go.
+
+The flags are:
+
+	-db <path>
+		The SQLite database file. Defaults to the CFG_DB_FILENAME environment variable, as for the web API, or else to
+		/tmp/decaf.db.
+
+Return values (exit codes):
+
+	0
+		The command succeeded
+	> 0
+		The command failed, or the usage is wrong
+*/
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	defaultPath := os.Getenv("CFG_DB_FILENAME")
+	if defaultPath == "" {
+		defaultPath = "/tmp/decaf.db"
+	}
+	var path = flag.String("db", defaultPath, "SQLite database file")
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		return errors.New("expected a command, and optionally a version")
+	}
+	if _, err := os.Stat(*path); err != nil {
+		return fmt.Errorf("opening the database: %w", err)
+	}
+	db, err := sql.Open("sqlite3", *path)
+	if err != nil {
+		return fmt.Errorf("opening SQLite: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+	version, err := database.SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	command := flag.Arg(0)
+	switch command {
+	case "status":
+		if flag.NArg() != 1 {
+			return errors.New("status takes no version")
+		}
+		return printStatus(db, version)
+	case "up":
+		target := database.LatestSchemaVersion()
+		if flag.NArg() == 2 {
+			if target, err = strconv.Atoi(flag.Arg(1)); err != nil {
+				return fmt.Errorf("invalid version %q", flag.Arg(1))
+			}
+		}
+		if target < version {
+			return fmt.Errorf("the schema is at version %d, use down to roll back to %d", version, target)
+		}
+		err = database.MigrateTo(db, target)
+	case "down":
+		target := version - 1
+		if flag.NArg() == 2 {
+			if target, err = strconv.Atoi(flag.Arg(1)); err != nil {
+				return fmt.Errorf("invalid version %q", flag.Arg(1))
+			}
+		}
+		if target > version {
+			return fmt.Errorf("the schema is at version %d, use up to migrate to %d", version, target)
+		}
+		if lowest := database.LowestReachableVersion(version); target < lowest {
+			return fmt.Errorf("migration %d cannot be rolled back, so the schema can go down to version %d at the "+
+				"lowest; nothing was rolled back", lowest, lowest)
+		}
+		err = database.MigrateTo(db, target)
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+	// Report where the schema stopped, also when a migration failed midway.
+	if version, verr := database.SchemaVersion(db); verr == nil {
+		fmt.Printf("schema at version %d of %d\n", version, database.LatestSchemaVersion())
+	}
+	return err
+}
+
+func printStatus(db *sql.DB, version int) error {
+	status, err := database.GetMigrationStatus(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema at version %d of %d\n", version, database.LatestSchemaVersion())
+	fmt.Printf("can be rolled back down to version %d\n", database.LowestReachableVersion(version))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED\tREVERSIBLE")
+	for _, s := range status {
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%t\t%t\n", s.Version, s.Name, s.Applied, s.Reversible)
+	}
+	return w.Flush()
+}
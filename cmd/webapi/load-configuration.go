@@ -21,6 +21,23 @@ type WebAPIConfiguration struct {
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
+		// WebSocketOrigins lists the origins of the pages, other than the API's own, that may open WebSockets.
+		WebSocketOrigins []string
+	}
+	Auth struct {
+		SessionTTL             time.Duration `conf:"default:168h"`
+		AllowPasswordlessLogin bool          `conf:"default:true"`
+	}
+	// Attachments restrict the files sent with messages. When left empty, the defaults of the api package apply.
+	Attachments struct {
+		AllowedTypes []string
+		DeniedTypes  []string
+		MaxSize      int64
+		// MaxMessageSize bounds the whole message request, all attachments included.
+		MaxMessageSize int64
+	}
+	Messages struct {
+		EditWindow time.Duration `conf:"default:15m"`
 	}
 	Debug bool
 	DB    struct {
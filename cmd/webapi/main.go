@@ -63,8 +63,16 @@ func run() error {
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	serverErrors := make(chan error, 1)
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:                 logger,
+		Database:               db,
+		SessionTTL:             cfg.Auth.SessionTTL,
+		AllowPasswordlessLogin: cfg.Auth.AllowPasswordlessLogin,
+		AttachmentTypes:        cfg.Attachments.AllowedTypes,
+		DeniedAttachmentTypes:  cfg.Attachments.DeniedTypes,
+		MaxAttachmentSize:      cfg.Attachments.MaxSize,
+		MaxMessageSize:         cfg.Attachments.MaxMessageSize,
+		MessageEditWindow:      cfg.Messages.EditWindow,
+		WebSocketOrigins:       cfg.Web.WebSocketOrigins,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
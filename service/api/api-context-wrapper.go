@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gofrs/uuid"
@@ -13,8 +14,23 @@ import (
 // required by the httprouter package.
 type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext)
 
-// wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
-func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+// authPolicy tells wrap whether a route requires an authenticated caller.
+type authPolicy int
+
+const (
+	// public routes are served to anyone, e.g. the login.
+	public authPolicy = iota
+	// authenticated routes require a valid session token. Requests without one are rejected with 401 before the
+	// handler runs.
+	authenticated
+	// authenticatedWithQueryToken routes are authenticated, but also accept the token in the "token" query parameter,
+	// for browser clients that cannot set headers: EventSource, WebSocket and <img> elements.
+	authenticatedWithQueryToken
+)
+
+// wrap parses the request and adds a reqcontext.RequestContext instance related to the request. When the policy is
+// authenticated, the session token is resolved and the caller is stored in the context.
+func (rt *_router) wrap(fn httpRouterHandler, policy authPolicy) func(http.ResponseWriter, *http.Request, httprouter.Params) {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		reqUUID, err := uuid.NewV4()
 		if err != nil {
@@ -32,6 +48,25 @@ func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Re
 			"remote-ip": r.RemoteAddr,
 		})
 
+		if policy == authenticated || policy == authenticatedWithQueryToken {
+			token := bearerToken(r)
+			if token == "" && policy == authenticatedWithQueryToken {
+				token = r.URL.Query().Get("token")
+			}
+			session, err := rt.getAuthenticatedSession(token)
+			if errors.Is(err, ErrUnauthorized) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				ctx.Logger.WithError(err).Error("can't resolve the session")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			ctx.UserID = session.UserId
+			ctx.SessionID = session.Id
+			ctx.Logger = ctx.Logger.WithField("user", ctx.UserID)
+		}
+
 		// Call the next handler in chain (usually, the handler function for the path)
 		fn(w, r, ps, ctx)
 	}
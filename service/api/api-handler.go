@@ -6,26 +6,50 @@ import (
 
 // Handler returns an instance of httprouter.Router that handle APIs registered here
 func (rt *_router) Handler() http.Handler {
-	rt.router.POST("/session", rt.wrap(rt.doLogin))
-	rt.router.GET("/users/photo", rt.wrap(rt.getMyPhoto))
-	rt.router.PUT("/users/photo", rt.wrap(rt.setMyPhoto))
-	rt.router.PUT("/users/name", rt.wrap(rt.setMyUserName))
-	rt.router.GET("/conversations", rt.wrap(rt.getMyConversations))
-	rt.router.POST("/conversations", rt.wrap(rt.startConversation))
-	rt.router.GET("/groups", rt.wrap(rt.getMyGroups))
-	rt.router.POST("/groups", rt.wrap(rt.createGroup))
-	rt.router.GET("/search", rt.wrap(rt.searchUsers))
-	rt.router.GET("/conversations/:conversationId", rt.wrap(rt.getConversation))
-	rt.router.POST("/conversations/:conversationId/message", rt.wrap(rt.sendMessage))
-	rt.router.DELETE("/conversations/:conversationId/message/:messageId", rt.wrap(rt.deleteMessage))
-	rt.router.POST("/conversations/:conversationId/message/:messageId/forward", rt.wrap(rt.forwardMessage))
-	rt.router.POST("/conversations/:conversationId/message/:messageId/comment", rt.wrap(rt.commentMessage))
-	rt.router.DELETE("/conversations/:conversationId/message/:messageId/comment", rt.wrap(rt.uncommentMessage))
-	rt.router.GET("/groups/:groupId", rt.wrap(rt.getGroup))
-	rt.router.DELETE("/groups/:groupId", rt.wrap(rt.leaveGroup))
-	rt.router.POST("/groups/:groupId", rt.wrap(rt.addToGroup))
-	rt.router.PUT("/groups/:groupId/name", rt.wrap(rt.setGroupName))
-	rt.router.PUT("/groups/:groupId/photo", rt.wrap(rt.setGroupPhoto))
+	rt.router.POST("/session", rt.wrap(rt.doLogin, public))
+	rt.router.DELETE("/session", rt.wrap(rt.doLogout, authenticated))
+	rt.router.GET("/sessions", rt.wrap(rt.getMySessions, authenticated))
+	rt.router.DELETE("/sessions/:sessionId", rt.wrap(rt.revokeSession, authenticated))
+	rt.router.GET("/users/photo", rt.wrap(rt.getMyPhoto, authenticated))
+	rt.router.PUT("/users/photo", rt.wrap(rt.setMyPhoto, authenticated))
+	rt.router.PUT("/users/name", rt.wrap(rt.setMyUserName, authenticated))
+	rt.router.PUT("/users/password", rt.wrap(rt.setMyPassword, authenticated))
+	rt.router.GET("/users/unread", rt.wrap(rt.getUnreadCount, authenticated))
+	rt.router.GET("/conversations", rt.wrap(rt.getMyConversations, authenticated))
+	rt.router.POST("/conversations", rt.wrap(rt.startConversation, authenticated))
+	rt.router.GET("/groups", rt.wrap(rt.getMyGroups, authenticated))
+	rt.router.POST("/groups", rt.wrap(rt.createGroup, authenticated))
+	rt.router.GET("/search", rt.wrap(rt.searchUsers, authenticated))
+	rt.router.GET("/search/messages", rt.wrap(rt.searchMessages, authenticated))
+	rt.router.GET("/conversations/:conversationId", rt.wrap(rt.getConversation, authenticated))
+	rt.router.GET("/conversations/:conversationId/photo", rt.wrap(rt.getConversationPhoto, authenticatedWithQueryToken))
+	rt.router.POST("/conversations/:conversationId/message", rt.wrap(rt.sendMessage, authenticated))
+	rt.router.POST("/conversations/:conversationId/delivered", rt.wrap(rt.acknowledgeDelivery, authenticated))
+	rt.router.POST("/conversations/:conversationId/read", rt.wrap(rt.acknowledgeRead, authenticated))
+	rt.router.PATCH("/conversations/:conversationId/message/:messageId", rt.wrap(rt.editMessage, authenticated))
+	rt.router.DELETE("/conversations/:conversationId/message/:messageId", rt.wrap(rt.deleteMessage, authenticated))
+	rt.router.GET("/conversations/:conversationId/message/:messageId/edits", rt.wrap(rt.getMessageEdits, authenticated))
+	rt.router.GET("/conversations/:conversationId/message/:messageId/receipts", rt.wrap(rt.getMessageReceipts, authenticated))
+	rt.router.GET("/conversations/:conversationId/message/:messageId/around", rt.wrap(rt.getMessagesAround, authenticated))
+	rt.router.POST("/conversations/:conversationId/message/:messageId/forward", rt.wrap(rt.forwardMessage, authenticated))
+	rt.router.PUT("/conversations/:conversationId/message/:messageId/reactions/:emoji", rt.wrap(rt.addReaction, authenticated))
+	rt.router.DELETE("/conversations/:conversationId/message/:messageId/reactions/:emoji", rt.wrap(rt.removeReaction, authenticated))
+	rt.router.GET("/conversations/:conversationId/message/:messageId/comments", rt.wrap(rt.getComments, authenticated))
+	rt.router.POST("/conversations/:conversationId/message/:messageId/comments", rt.wrap(rt.addComment, authenticated))
+	rt.router.PATCH("/conversations/:conversationId/message/:messageId/comments/:commentId", rt.wrap(rt.editComment, authenticated))
+	rt.router.DELETE("/conversations/:conversationId/message/:messageId/comments/:commentId", rt.wrap(rt.deleteComment, authenticated))
+	rt.router.GET("/groups/:groupId", rt.wrap(rt.getGroup, authenticated))
+	rt.router.DELETE("/groups/:groupId", rt.wrap(rt.leaveGroup, authenticated))
+	rt.router.POST("/groups/:groupId", rt.wrap(rt.addToGroup, authenticated))
+	rt.router.PUT("/groups/:groupId/name", rt.wrap(rt.setGroupName, authenticated))
+	rt.router.PUT("/groups/:groupId/photo", rt.wrap(rt.setGroupPhoto, authenticated))
+	rt.router.PUT("/groups/:groupId/owner", rt.wrap(rt.transferGroupOwnership, authenticated))
+	rt.router.DELETE("/groups/:groupId/members/:userId", rt.wrap(rt.removeFromGroup, authenticated))
+	rt.router.PUT("/groups/:groupId/members/:userId/role", rt.wrap(rt.setMemberRole, authenticated))
+	rt.router.GET("/events", rt.wrap(rt.streamEvents, authenticatedWithQueryToken))
+	rt.router.GET("/ws", rt.wrap(rt.openChatSocket, authenticatedWithQueryToken))
+	rt.router.GET("/media/:mediaId", rt.wrap(rt.getMedia, authenticatedWithQueryToken))
+	rt.router.GET("/media/:mediaId/:variant", rt.wrap(rt.getMediaVariant, authenticatedWithQueryToken))
 	rt.router.GET("/liveness", rt.liveness)
 	return rt.router
 }
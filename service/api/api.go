@@ -3,6 +3,7 @@ package api
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nazerke1234/wasa/service/database"
@@ -16,8 +17,59 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// SessionTTL is how long a session token stays valid after login
+	SessionTTL time.Duration
+
+	// AllowPasswordlessLogin lets accounts that never set a password log in with their name only
+	AllowPasswordlessLogin bool
+
+	// AttachmentTypes are the MIME types that may be sent as attachments, such as "application/pdf" or "audio/*".
+	// If empty, DefaultAttachmentTypes are allowed
+	AttachmentTypes []string
+
+	// DeniedAttachmentTypes are MIME types refused even when AttachmentTypes allow them
+	DeniedAttachmentTypes []string
+
+	// MaxAttachmentSize is the largest attachment accepted, in bytes. If zero, DefaultMaxAttachmentSize is used
+	MaxAttachmentSize int64
+
+	// MaxMessageSize is the largest message request accepted, attachments included, in bytes. If zero,
+	// DefaultMaxMessageSize is used, raised to fit one attachment of MaxAttachmentSize
+	MaxMessageSize int64
+
+	// MessageEditWindow is how long after sending a message its sender may still edit it
+	MessageEditWindow time.Duration
+
+	// WebSocketOrigins are the origins, such as "https://chat.example.com", whose pages may open WebSockets besides
+	// the API's own origin
+	WebSocketOrigins []string
+}
+
+// DefaultAttachmentTypes are the attachment types allowed when none are configured: images, PDFs, plain text, zip
+// archives (which also covers office documents), audio and video.
+var DefaultAttachmentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"application/pdf",
+	"text/plain",
+	"application/zip",
+	"application/x-gzip",
+	"application/ogg",
+	"audio/*",
+	"video/*",
 }
 
+// DefaultMaxAttachmentSize is the attachment size limit used when none is configured.
+const DefaultMaxAttachmentSize = 20 << 20
+
+// DefaultMaxMessageSize is the message request size limit used when none is configured.
+const DefaultMaxMessageSize = 50 << 20
+
+// messageFormOverhead is room left in message requests for the content, captions and multipart headers.
+const messageFormOverhead = 1 << 20
+
 // Router is the package API interface representing an API handler builder
 type Router interface {
 	// Handler returns an HTTP handler for APIs provided in this package
@@ -36,6 +88,37 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if cfg.SessionTTL <= 0 {
+		return nil, errors.New("session TTL must be positive")
+	}
+	if cfg.MessageEditWindow <= 0 {
+		return nil, errors.New("message edit window must be positive")
+	}
+	if cfg.MaxAttachmentSize < 0 {
+		return nil, errors.New("max attachment size must not be negative")
+	}
+	if cfg.MaxMessageSize < 0 {
+		return nil, errors.New("max message size must not be negative")
+	}
+	attachments := uploadKind{
+		name:    "attachment",
+		maxSize: cfg.MaxAttachmentSize,
+		allowed: cfg.AttachmentTypes,
+		denied:  cfg.DeniedAttachmentTypes,
+	}
+	if attachments.maxSize == 0 {
+		attachments.maxSize = DefaultMaxAttachmentSize
+	}
+	if len(attachments.allowed) == 0 {
+		attachments.allowed = DefaultAttachmentTypes
+	}
+	maxMessageSize := cfg.MaxMessageSize
+	if maxMessageSize == 0 {
+		maxMessageSize = DefaultMaxMessageSize
+		if maxMessageSize < attachments.maxSize+messageFormOverhead {
+			maxMessageSize = attachments.maxSize + messageFormOverhead
+		}
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
@@ -44,9 +127,16 @@ func New(cfg Config) (Router, error) {
 	router.RedirectFixedPath = false
 
 	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
+		router:                 router,
+		baseLogger:             cfg.Logger,
+		db:                     cfg.Database,
+		sessionTTL:             cfg.SessionTTL,
+		allowPasswordlessLogin: cfg.AllowPasswordlessLogin,
+		attachments:            attachments,
+		maxMessageSize:         maxMessageSize,
+		messageEditWindow:      cfg.MessageEditWindow,
+		websocketOrigins:       cfg.WebSocketOrigins,
+		events:                 newEventHub(),
 	}, nil
 }
 
@@ -58,4 +148,22 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	sessionTTL time.Duration
+
+	allowPasswordlessLogin bool
+
+	// attachments is what sendMessage accepts as attachment.
+	attachments uploadKind
+
+	// maxMessageSize bounds the whole body of sendMessage requests, however many attachments they carry.
+	maxMessageSize int64
+
+	messageEditWindow time.Duration
+
+	// websocketOrigins are the origins allowed to open WebSockets besides the API's own.
+	websocketOrigins []string
+
+	// events delivers conversation changes to the open /events streams.
+	events *eventHub
 }
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+// Requests clients send over /ws.
+const (
+	socketSendMessage   = "send_message"
+	socketTypingStarted = "typing_started"
+	socketTypingStopped = "typing_stopped"
+	socketOnline        = "online"
+	socketOffline       = "offline"
+)
+
+// Ephemeral signals pushed to the other members of a conversation. They are never stored.
+const (
+	eventTyping   = "typing"
+	eventPresence = "presence"
+)
+
+// Replies sent only to the client that made a request.
+const (
+	socketAck   = "ack"
+	socketError = "error"
+)
+
+// socketPingInterval is how often the server pings idle clients; socketIdleTimeout is how long it waits for any frame
+// before giving up on a client. Browsers answer pings on their own.
+const (
+	socketPingInterval = 30 * time.Second
+	socketIdleTimeout  = 70 * time.Second
+)
+
+// SocketRequest is a JSON message sent by clients over /ws.
+type SocketRequest struct {
+	Type           string `json:"type"`
+	RequestID      string `json:"requestId,omitempty"`
+	ConversationID string `json:"conversationId"`
+	Content        string `json:"content,omitempty"`
+	ReplyTo        string `json:"replyTo,omitempty"`
+}
+
+// SocketReply answers a SocketRequest. Errors carry a message, acks of sent messages carry the saved message.
+type SocketReply struct {
+	Type      string      `json:"type"`
+	RequestID string      `json:"requestId,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// Signal is the data of the typing and presence events.
+type Signal struct {
+	UserID string `json:"userId"`
+	Active bool   `json:"active"`
+}
+
+// openChatSocket upgrades the request to a WebSocket carrying the caller's events one way and their messages and
+// typing and presence signals the other way.
+func (rt *_router) openChatSocket(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	sub, err := rt.events.subscribe(ctx.UserID, ctx.SessionID)
+	if err != nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer rt.events.unsubscribe(sub)
+	conn, err := upgradeWebsocket(w, r, rt.websocketOrigins)
+	if err != nil {
+		ctx.Logger.WithError(err).Debug("WebSocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go rt.writeChatSocket(ctx, conn, sub, done)
+
+	// Signals the client left active are withdrawn when it goes away.
+	typing := make(map[string]bool)
+	online := make(map[string]bool)
+	defer func() {
+		for conversationID := range typing {
+			_ = rt.publishSignal(ctx, conversationID, eventTyping, false)
+		}
+		for conversationID := range online {
+			_ = rt.publishSignal(ctx, conversationID, eventPresence, false)
+		}
+	}()
+
+	for {
+		opcode, payload, err := conn.readMessage(socketIdleTimeout)
+		if err != nil {
+			return
+		}
+		var req SocketRequest
+		if opcode != websocketText || json.Unmarshal(payload, &req) != nil {
+			rt.replyChatSocket(ctx, conn, SocketReply{Type: socketError, Error: "Invalid request"})
+			continue
+		}
+		rt.replyChatSocket(ctx, conn, rt.handleSocketRequest(ctx, req, typing, online))
+	}
+}
+
+// handleSocketRequest runs a client request, keeping track of the signals the client has active.
+func (rt *_router) handleSocketRequest(
+	ctx reqcontext.RequestContext,
+	req SocketRequest,
+	typing map[string]bool,
+	online map[string]bool,
+) SocketReply {
+	reply := SocketReply{Type: socketAck, RequestID: req.RequestID}
+	var err error
+	switch req.Type {
+	case socketSendMessage:
+		var message database.Message
+		message, err = rt.postMessage(ctx, req.ConversationID, req.Content, nil, req.ReplyTo)
+		if err == nil {
+			reply.Data = message
+			if typing[req.ConversationID] {
+				delete(typing, req.ConversationID)
+				_ = rt.publishSignal(ctx, req.ConversationID, eventTyping, false)
+			}
+		}
+	case socketTypingStarted, socketTypingStopped:
+		active := req.Type == socketTypingStarted
+		if err = rt.publishSignal(ctx, req.ConversationID, eventTyping, active); err == nil {
+			setSignal(typing, req.ConversationID, active)
+		}
+	case socketOnline, socketOffline:
+		active := req.Type == socketOnline
+		if err = rt.publishSignal(ctx, req.ConversationID, eventPresence, active); err == nil {
+			setSignal(online, req.ConversationID, active)
+		}
+	default:
+		return SocketReply{Type: socketError, RequestID: req.RequestID, Error: "Unknown request type"}
+	}
+	if err == nil {
+		return reply
+	}
+
+	reply = SocketReply{Type: socketError, RequestID: req.RequestID}
+	if errors.Is(err, ErrEmptyMessage) {
+		reply.Error = "Message content is required"
+	} else if errors.Is(err, database.ErrReplyNotInConversation) {
+		reply.Error = "The replied message is not in this conversation"
+	} else if errors.Is(err, database.ErrUserNotInConversation) {
+		reply.Error = "You are not a member of this conversation"
+	} else if errors.Is(err, database.ErrConversationDoesNotExist) {
+		reply.Error = "Conversation does not exist"
+	} else {
+		ctx.Logger.WithError(err).Error("Failed to handle WebSocket request")
+		reply.Error = "Internal Server Error"
+	}
+	return reply
+}
+
+func setSignal(signals map[string]bool, conversationID string, active bool) {
+	if active {
+		signals[conversationID] = true
+	} else {
+		delete(signals, conversationID)
+	}
+}
+
+// publishSignal pushes a typing or presence signal of the caller to the other members of the conversation.
+func (rt *_router) publishSignal(ctx reqcontext.RequestContext, conversationID, eventType string, active bool) error {
+	members, err := rt.db.GetConversationMembers(conversationID)
+	if err != nil {
+		return err
+	}
+	others := make([]string, 0, len(members))
+	isMember := false
+	for _, memberID := range members {
+		if memberID == ctx.UserID {
+			isMember = true
+		} else {
+			others = append(others, memberID)
+		}
+	}
+	if !isMember {
+		return database.ErrUserNotInConversation
+	}
+	rt.events.publish(Event{
+		Type:           eventType,
+		ConversationID: conversationID,
+		Data:           Signal{UserID: ctx.UserID, Active: active},
+	}, others)
+	return nil
+}
+
+// writeChatSocket forwards the caller's events to the socket and keeps it alive, until the reader stops or the event
+// hub drops the subscriber.
+func (rt *_router) writeChatSocket(
+	ctx reqcontext.RequestContext,
+	conn *websocketConn,
+	sub *eventSubscriber,
+	done <-chan struct{},
+) {
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			if err := conn.writeFrame(websocketPing, nil); err != nil {
+				_ = conn.Close()
+				return
+			}
+		case event, ok := <-sub.events:
+			if !ok {
+				_ = conn.writeClose(websocketCloseGoingAway)
+				_ = conn.Close()
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("Failed to encode event")
+				continue
+			}
+			if err := conn.writeFrame(websocketText, data); err != nil {
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (rt *_router) replyChatSocket(ctx reqcontext.RequestContext, conn *websocketConn, reply SocketReply) {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode WebSocket reply")
+		return
+	}
+	_ = conn.writeFrame(websocketText, data)
+}
@@ -1,69 +1,219 @@
 package api
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
 )
 
-func (rt *_router) commentMessage(
+// maxCommentLength is the longest comment accepted, in characters.
+const maxCommentLength = 1000
+
+// encodeCommentCursor returns the opaque cursor pointing at the comment.
+func encodeCommentCursor(comment database.Comment) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(comment.CreatedAt + "|" + comment.Id))
+}
+
+func decodeCommentCursor(cursor string) (*database.CommentCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("malformed cursor")
+	}
+	return &database.CommentCursor{CreatedAt: parts[0], Id: parts[1]}, nil
+}
+
+// readCommentContent decodes and validates the body of a comment request. On error, a 400 response is written.
+func readCommentContent(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req CommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, "Comment content is required", http.StatusBadRequest)
+		return "", false
+	}
+	if utf8.RuneCountInString(req.Content) > maxCommentLength {
+		http.Error(w, "Comment is too long", http.StatusBadRequest)
+		return "", false
+	}
+	return req.Content, true
+}
+
+// getComments returns a page of the comment thread of a message, oldest first.
+func (rt *_router) getComments(
 	w http.ResponseWriter,
 	r *http.Request,
 	ps httprouter.Params,
 	ctx reqcontext.RequestContext,
 ) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	conversationID := ps.ByName("conversationId")
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
 		return
 	}
-
-	userID, err := rt.getAuthenticatedUserID(r)
-
-	if err != nil {
-		http.Error(w, "Unauthorized: ", http.StatusUnauthorized)
+	limit, ok := parseMessageLimit(w, r)
+	if !ok {
 		return
 	}
+	query := database.CommentQuery{Limit: limit}
+	if after := r.URL.Query().Get("after"); after != "" {
+		var err error
+		if query.After, err = decodeCommentCursor(after); err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	page, err := rt.db.GetComments(conversationID, ps.ByName("messageId"), query)
+	if errors.Is(err, database.ErrMessageDoesNotExist) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch comments")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	response := CommentPageResponse{Comments: page.Comments}
+	if page.HasMore {
+		response.NextCursor = encodeCommentCursor(page.Comments[len(page.Comments)-1])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode comments")
+	}
+}
 
+func (rt *_router) addComment(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	conversationID := ps.ByName("conversationId")
+	content, ok := readCommentContent(w, r)
+	if !ok {
+		return
+	}
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		return
+	}
 	commentID, err := generateNewID()
-
 	if err != nil {
-		ctx.Logger.WithError(err).Error("Failed to generate conversation ID")
+		ctx.Logger.WithError(err).Error("Failed to generate comment ID")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-
-	if err := rt.db.CommentMessage(commentID, ps.ByName("messageId"), userID); err != nil {
+	comment, err := rt.db.AddComment(conversationID, ps.ByName("messageId"), commentID, ctx.UserID, content)
+	if errors.Is(err, database.ErrMessageDoesNotExist) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrMessageDeleted) {
+		http.Error(w, "Forbidden: deleted messages cannot get comments", http.StatusForbidden)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to add comment")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-
-	w.WriteHeader(http.StatusNoContent)
+	rt.publishEvent(ctx, conversationID, eventCommentAdded, comment)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(comment); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode response")
+	}
 }
 
-func (rt *_router) uncommentMessage(
+func (rt *_router) editComment(
 	w http.ResponseWriter,
 	r *http.Request,
 	ps httprouter.Params,
 	ctx reqcontext.RequestContext,
 ) {
-
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	conversationID := ps.ByName("conversationId")
+	content, ok := readCommentContent(w, r)
+	if !ok {
 		return
 	}
-
-	userID, err := rt.getAuthenticatedUserID(r)
-
-	if err != nil {
-		http.Error(w, "Unauthorized: ", http.StatusUnauthorized)
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
 		return
 	}
-
-	if err := rt.db.UncommentMessage(ps.ByName("messageId"), userID); err != nil {
+	comment, err := rt.db.EditComment(conversationID, ps.ByName("messageId"), ps.ByName("commentId"), ctx.UserID, content)
+	if errors.Is(err, database.ErrCommentDoesNotExist) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrUnauthorizedToChangeComment) {
+		http.Error(w, "Forbidden: You are not the author of this comment", http.StatusForbidden)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to edit comment")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	rt.publishEvent(ctx, conversationID, eventCommentEdited, comment)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(comment); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode response")
+	}
+}
 
+func (rt *_router) deleteComment(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	conversationID := ps.ByName("conversationId")
+	messageID := ps.ByName("messageId")
+	commentID := ps.ByName("commentId")
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		return
+	}
+	err := rt.db.DeleteComment(conversationID, messageID, commentID, ctx.UserID)
+	if errors.Is(err, database.ErrCommentDoesNotExist) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrUnauthorizedToChangeComment) {
+		http.Error(w, "Forbidden: You are not the author of this comment", http.StatusForbidden)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to delete comment")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.publishEvent(ctx, conversationID, eventCommentDeleted, MessageRef{MessageID: messageID, CommentID: commentID})
 	w.WriteHeader(http.StatusNoContent)
 }
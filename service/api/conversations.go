@@ -3,9 +3,8 @@ package api
 import (
 	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nazerke1234/wasa/service/api/reqcontext"
@@ -26,11 +25,23 @@ func (rt *_router) startConversation(
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	if req.SenderID == "" || req.RecipientID == "" {
-		http.Error(w, "Missing senderId or recipientId", http.StatusBadRequest)
+	if req.RecipientID == "" {
+		http.Error(w, "Missing recipientId", http.StatusBadRequest)
 		return
 	}
-	conversationID, err := rt.db.GetDirectConversation(req.SenderID, req.RecipientID)
+	if req.SenderID != "" && req.SenderID != ctx.UserID {
+		http.Error(w, "Forbidden: senderId does not match the authenticated user", http.StatusForbidden)
+		return
+	}
+	if _, err := rt.db.GetUserById(req.RecipientID); errors.Is(err, database.ErrUserDoesNotExist) {
+		http.Error(w, "Recipient not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch recipient")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	conversationID, err := rt.db.GetDirectConversation(ctx.UserID, req.RecipientID)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to check conversation existence")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -43,12 +54,13 @@ func (rt *_router) startConversation(
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		err = rt.db.CreateDirectConversation(conversationID, req.SenderID, req.RecipientID)
+		err = rt.db.CreateDirectConversation(conversationID, ctx.UserID, req.RecipientID)
 		if err != nil {
 			ctx.Logger.WithError(err).Error("Failed to create new conversation")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		rt.publishEvent(ctx, conversationID, eventConversationCreated, nil)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]string{
@@ -69,11 +81,7 @@ func (rt *_router) getConversation(
 		http.Error(w, "Missing conversationId", http.StatusBadRequest)
 		return
 	}
-	userID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := ctx.UserID
 	isMember, err := rt.db.IsUserInConversation(conversationID, userID)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to check conversation membership")
@@ -84,9 +92,11 @@ func (rt *_router) getConversation(
 		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
 		return
 	}
-	if err := rt.db.MarkMessagesAsRead(conversationID, userID); err != nil {
-		ctx.Logger.WithError(err).Error("Failed to mark messages as read")
+	query, ok := parseMessageQuery(w, r)
+	if !ok {
+		return
 	}
+	query.UserID = userID
 	conversation, err := rt.db.GetConversationDetails(conversationID, userID)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to fetch conversation details")
@@ -97,6 +107,14 @@ func (rt *_router) getConversation(
 		}
 		return
 	}
+	page, err := rt.db.GetMessages(conversationID, query)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch conversation messages")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	conversation.Messages = page.Messages
+	conversation.PrevCursor, conversation.NextCursor = pageCursors(page)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(conversation); err != nil {
 		ctx.Logger.WithError(err).Error("Failed to encode conversation details")
@@ -114,79 +132,149 @@ func (rt *_router) sendMessage(
 		http.Error(w, "Missing conversationId", http.StatusBadRequest)
 		return
 	}
+	// Attachments are read into memory, so the request as a whole is bounded, not only each file.
+	r.Body = http.MaxBytesReader(w, r.Body, rt.maxMessageSize)
 	err := r.ParseMultipartForm(32 << 20)
-	if err != nil {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("The message is too large. Maximum allowed size is %d MB.", rt.maxMessageSize>>20),
+			http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
 		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
 		return
 	}
 	content := r.FormValue("content")
 	replyTo := r.FormValue("replyTo")
-	var attachment []byte
-	file, header, err := r.FormFile("attachment")
-	if err == nil {
-		defer file.Close()
-		allowedTypes := map[string]bool{
-			"image/jpeg": true,
-			"image/png":  true,
-			"image/gif":  true,
-		}
-		if !allowedTypes[header.Header.Get("Content-Type")] {
-			http.Error(w, "Invalid file type. Only images and GIFs are allowed", http.StatusBadRequest)
-			return
-		}
-		attachment, err = io.ReadAll(file)
-		if err != nil {
-			ctx.Logger.WithError(err).Error("Failed to read attachment")
-			http.Error(w, "Failed to process attachment", http.StatusInternalServerError)
-			return
-		}
-	} else if !errors.Is(err, http.ErrMissingFile) {
-		ctx.Logger.WithError(err).Error("Error retrieving file")
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
-	}
-	if content == "" && len(attachment) == 0 {
-		http.Error(w, "Message content or attachment is required", http.StatusBadRequest)
+	uploads, ok := receiveUploads(w, r, ctx, "attachment", rt.attachments, maxMessageAttachments)
+	if !ok {
 		return
 	}
-	senderID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Captions go with the attachments at the same positions; trailing ones may be left out.
+	captions := r.MultipartForm.Value["caption"]
+	if len(captions) > len(uploads) {
+		http.Error(w, "There are more captions than attachments", http.StatusBadRequest)
 		return
 	}
-	messageID, err := generateNewID()
-	if err != nil {
-		ctx.Logger.WithError(err).Error("Failed to generate message ID")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	attachments := make([]pendingAttachment, len(uploads))
+	for i, u := range uploads {
+		attachments[i].upload = u
+		if i < len(captions) {
+			attachments[i].caption = captions[i]
+		}
 	}
-	message, err := rt.db.SaveMessage(conversationID, senderID, messageID, content, attachment, replyTo)
+	message, err := rt.postMessage(ctx, conversationID, content, attachments, replyTo)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("Failed to save message")
-		if errors.Is(err, database.ErrConversationDoesNotExist) {
+		if errors.Is(err, ErrEmptyMessage) {
+			http.Error(w, "Message content or attachment is required", http.StatusBadRequest)
+		} else if errors.Is(err, database.ErrReplyNotInConversation) {
+			http.Error(w, "The replied message is not in this conversation", http.StatusBadRequest)
+		} else if errors.Is(err, database.ErrUserNotInConversation) {
+			http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		} else if errors.Is(err, database.ErrConversationDoesNotExist) {
 			http.Error(w, "Conversation does not exist", http.StatusNotFound)
 		} else {
+			ctx.Logger.WithError(err).Error("Failed to save message")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode response")
+	}
+}
+
+// maxMessageAttachments is the number of files a message can carry.
+const maxMessageAttachments = 10
+
+// pendingAttachment is a file sent with a message, not stored yet.
+type pendingAttachment struct {
+	upload  *upload
+	caption string
+}
+
+// postMessage saves a message of the caller, records its delivery receipts and pushes it to the members of the
+// conversation. It is shared by the REST and WebSocket transports, so both apply the same checks.
+func (rt *_router) postMessage(
+	ctx reqcontext.RequestContext,
+	conversationID, content string,
+	pending []pendingAttachment,
+	replyTo string,
+) (database.Message, error) {
+	if content == "" && len(pending) == 0 {
+		return database.Message{}, ErrEmptyMessage
+	}
 	members, err := rt.db.GetConversationMembers(conversationID)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("Failed to fetch conversation members")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return database.Message{}, fmt.Errorf("fetching conversation members: %w", err)
 	}
+	isMember := false
 	for _, memberID := range members {
-		if memberID != senderID {
-			if err := rt.db.InsertDeliveryReceipt(messageID, memberID, message.Timestamp); err != nil {
-				ctx.Logger.WithError(err).Error("Failed to insert delivery receipt")
-			}
+		if memberID == ctx.UserID {
+			isMember = true
 		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(message); err != nil {
-		ctx.Logger.WithError(err).Error("Failed to encode response")
+	if !isMember {
+		return database.Message{}, database.ErrUserNotInConversation
+	}
+	messageID, err := generateNewID()
+	if err != nil {
+		return database.Message{}, fmt.Errorf("generating message ID: %w", err)
+	}
+	media := make([]preparedMedia, 0, len(pending))
+	attachments := make([]database.Attachment, 0, len(pending))
+	for _, p := range pending {
+		m, err := prepareMedia(ctx, p.upload)
+		if err != nil {
+			return database.Message{}, fmt.Errorf("preparing attachment: %w", err)
+		}
+		media = append(media, m)
+		attachments = append(attachments, database.Attachment{Media: m.media, Caption: p.caption})
+	}
+	message, err := rt.saveMessage(conversationID, ctx.UserID, messageID, content, media, attachments, replyTo, members)
+	if err != nil {
+		return database.Message{}, err
+	}
+	rt.events.publish(Event{Type: eventMessageCreated, ConversationID: conversationID, Data: message}, members)
+	return message, nil
+}
+
+// saveMessage saves a message along with the new media it carries and a receipt for each of the other members, in one
+// transaction, so that no message is left without the receipts its status is computed from, and no media is left
+// without its message.
+func (rt *_router) saveMessage(
+	conversationID, senderID, messageID, content string,
+	media []preparedMedia,
+	attachments []database.Attachment,
+	replyTo string,
+	members []string,
+) (database.Message, error) {
+	var message database.Message
+	err := rt.db.WithTx(func(tx database.AppDatabase) error {
+		for _, m := range media {
+			if err := saveMedia(tx, m); err != nil {
+				return fmt.Errorf("storing attachment: %w", err)
+			}
+		}
+		var err error
+		message, err = tx.SaveMessage(conversationID, senderID, messageID, content, attachments, replyTo)
+		if err != nil {
+			return err
+		}
+		for _, memberID := range members {
+			if memberID != senderID {
+				if err := tx.InsertReceipt(messageID, memberID); err != nil {
+					return fmt.Errorf("inserting receipt: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return database.Message{}, err
 	}
+	return message, nil
 }
 
 func (rt *_router) getMyConversations(
@@ -195,11 +283,7 @@ func (rt *_router) getMyConversations(
 	ps httprouter.Params,
 	ctx reqcontext.RequestContext,
 ) {
-	userID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := ctx.UserID
 	conversations, err := rt.db.GetMyConversations(userID)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to fetch user's conversations")
@@ -212,6 +296,13 @@ func (rt *_router) getMyConversations(
 	}
 }
 
+// Deletion modes of deleteMessage. Deleting for everyone leaves a tombstone in place of the message; deleting for me
+// hides the message from the caller only.
+const (
+	deleteForEveryone = "everyone"
+	deleteForMe       = "me"
+)
+
 func (rt *_router) deleteMessage(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -220,9 +311,10 @@ func (rt *_router) deleteMessage(
 ) {
 	conversationID := ps.ByName("conversationId")
 	messageID := ps.ByName("messageId")
-	userID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	userID := ctx.UserID
+	mode := r.URL.Query().Get("for")
+	if mode != "" && mode != deleteForEveryone && mode != deleteForMe {
+		http.Error(w, "Invalid deletion mode, expected everyone or me", http.StatusBadRequest)
 		return
 	}
 	if ok, err := rt.db.IsUserInConversation(conversationID, userID); !ok {
@@ -235,21 +327,128 @@ func (rt *_router) deleteMessage(
 		return
 	}
 
-	err = rt.db.DeleteMessage(conversationID, messageID, userID)
+	if mode == deleteForMe {
+		err := rt.db.HideMessage(conversationID, messageID, userID)
+		if errors.Is(err, database.ErrMessageDoesNotExist) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to hide message")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		// Only the other sessions of the caller need to know.
+		rt.events.publish(Event{
+			Type:           eventMessageHidden,
+			ConversationID: conversationID,
+			Data:           MessageRef{MessageID: messageID, UserID: userID},
+		}, []string{userID})
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	err := rt.db.DeleteMessage(conversationID, messageID, userID)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to delete message")
 		if errors.Is(err, database.ErrMessageDoesNotExist) {
 			http.Error(w, "Message not found", http.StatusNotFound)
 		} else if errors.Is(err, database.ErrUnauthorizedToDeleteMessage) {
 			http.Error(w, "Forbidden: You are not the sender of this message", http.StatusForbidden)
+		} else if errors.Is(err, database.ErrSystemMessage) {
+			http.Error(w, "Forbidden: system messages cannot be deleted", http.StatusForbidden)
 		} else {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
+	rt.publishEvent(ctx, conversationID, eventMessageDeleted, MessageRef{MessageID: messageID})
 	w.WriteHeader(http.StatusOK)
 }
 
+func (rt *_router) editMessage(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	conversationID := ps.ByName("conversationId")
+	messageID := ps.ByName("messageId")
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Content == "" {
+		http.Error(w, "Message content is required", http.StatusBadRequest)
+		return
+	}
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		return
+	}
+	message, err := rt.db.EditMessage(conversationID, messageID, ctx.UserID, req.Content, rt.messageEditWindow)
+	if err != nil {
+		if errors.Is(err, database.ErrMessageDoesNotExist) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+		} else if errors.Is(err, database.ErrUnauthorizedToEditMessage) {
+			http.Error(w, "Forbidden: You are not the sender of this message", http.StatusForbidden)
+		} else if errors.Is(err, database.ErrSystemMessage) {
+			http.Error(w, "Forbidden: system messages cannot be edited", http.StatusForbidden)
+		} else if errors.Is(err, database.ErrMessageDeleted) {
+			http.Error(w, "Forbidden: deleted messages cannot be edited", http.StatusForbidden)
+		} else if errors.Is(err, database.ErrEditWindowExpired) {
+			http.Error(w, "Forbidden: the message can no longer be edited", http.StatusForbidden)
+		} else {
+			ctx.Logger.WithError(err).Error("Failed to edit message")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	rt.publishEvent(ctx, conversationID, eventMessageEdited, message)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode response")
+	}
+}
+
+func (rt *_router) getMessageEdits(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	conversationID := ps.ByName("conversationId")
+	messageID := ps.ByName("messageId")
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		return
+	}
+	edits, err := rt.db.GetMessageEdits(conversationID, messageID)
+	if errors.Is(err, database.ErrMessageDoesNotExist) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch message edits")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(edits); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode message edits")
+	}
+}
+
 func (rt *_router) forwardMessage(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -259,15 +458,25 @@ func (rt *_router) forwardMessage(
 	messageID := ps.ByName("messageId")
 	var req struct {
 		TargetConversationID string `json:"targetConversationId"`
-		ForwarderName        string `json:"forwarderName"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	currentUserID, err := rt.getAuthenticatedUserID(r)
+	currentUserID := ctx.UserID
+	if ok, err := rt.db.IsUserInConversation(req.TargetConversationID, currentUserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check target conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of the target conversation", http.StatusForbidden)
+		return
+	}
+	forwarder, err := rt.db.GetUserById(currentUserID)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		ctx.Logger.WithError(err).Error("Failed to fetch forwarding user")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	originalMessage, err := rt.db.GetMessage(messageID, currentUserID)
@@ -279,45 +488,38 @@ func (rt *_router) forwardMessage(
 		}
 		return
 	}
+	if originalMessage.DeletedAt != "" {
+		http.Error(w, "Deleted messages cannot be forwarded", http.StatusBadRequest)
+		return
+	}
 	newMessageID, err := generateNewID()
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to generate new message ID")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	newContent := "<strong>Forwarded from " + req.ForwarderName + ":</strong> " + originalMessage.Content
-	newMessage := database.Message{
-		Id:             newMessageID,
-		ConversationId: req.TargetConversationID,
-		SenderId:       currentUserID,
-		SenderName:     req.ForwarderName,
-		Content:        newContent,
-		Timestamp:      time.Now().Format(time.RFC3339),
-		Attachment:     originalMessage.Attachment,
-	}
-	if _, err := rt.db.SaveMessage(
-		newMessage.ConversationId,
-		newMessage.SenderId,
-		newMessage.Id,
-		newMessage.Content,
-		newMessage.Attachment,
-		"",
-	); err != nil {
-		ctx.Logger.WithError(err).Error("Failed to save forwarded message")
+	newContent := "<strong>Forwarded from " + forwarder.Name + ":</strong> " + originalMessage.Content
+	members, err := rt.db.GetConversationMembers(req.TargetConversationID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch conversation members for forwarded message")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	members, err := rt.db.GetConversationMembers(req.TargetConversationID)
+	saved, err := rt.saveMessage(
+		req.TargetConversationID,
+		currentUserID,
+		newMessageID,
+		newContent,
+		nil,
+		originalMessage.Attachments,
+		"",
+		members,
+	)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("Failed to fetch conversation members for forwarded message")
-	} else {
-		for _, memberID := range members {
-			if memberID != currentUserID {
-				if err := rt.db.InsertDeliveryReceipt(newMessageID, memberID, newMessage.Timestamp); err != nil {
-					ctx.Logger.WithError(err).Error("Failed to insert delivery receipt for forwarded message")
-				}
-			}
-		}
+		ctx.Logger.WithError(err).Error("Failed to save forwarded message")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
+	rt.events.publish(Event{Type: eventMessageCreated, ConversationID: req.TargetConversationID, Data: saved}, members)
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+// newTestRouter returns a router on a migrated database in a temporary file, along with the connection to the
+// database for checking what was written. Foreign keys are enforced on every connection.
+func newTestRouter(t *testing.T) (*_router, *sql.DB) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"alice", "bob"} {
+		if _, err := db.CreateUser(database.User{Id: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreateDirectConversation("ab", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	return &_router{db: db, events: newEventHub()}, conn
+}
+
+func countRows(t *testing.T, conn *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func testMedia(id string) preparedMedia {
+	return preparedMedia{
+		media: database.Media{
+			Id:         id,
+			FileName:   "notes.txt",
+			MimeType:   "text/plain",
+			Size:       5,
+			UploaderId: "alice",
+			CreatedAt:  "2025-01-01T00:00:00Z",
+		},
+		data: []byte("notes"),
+		variants: []database.MediaVariant{
+			{Name: "small", MimeType: "image/jpeg", Width: 1, Height: 1, Data: []byte("thumb")},
+		},
+	}
+}
+
+func TestSaveMessage(t *testing.T) {
+	rt, conn := newTestRouter(t)
+	media := []preparedMedia{testMedia("m1")}
+	attachments := []database.Attachment{{Media: media[0].media, Caption: "notes"}}
+	message, err := rt.saveMessage("ab", "alice", "msg", "hello", media, attachments, "", []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Id != "msg" || len(message.Attachments) != 1 {
+		t.Errorf("got message %+v", message)
+	}
+	want := map[string]int{"messages": 1, "read_receipts": 1, "media": 1, "media_variants": 1, "message_attachments": 1}
+	for table, n := range want {
+		if got := countRows(t, conn, table); got != n {
+			t.Errorf("got %d rows in %s, want %d", got, table, n)
+		}
+	}
+}
+
+func TestSaveMessageRollsBack(t *testing.T) {
+	tests := []struct {
+		name        string
+		media       []preparedMedia
+		attachments []database.Attachment
+		members     []string
+	}{
+		// The message and its media are written before the receipts fail.
+		{
+			name:    "receipt for a missing member",
+			media:   []preparedMedia{testMedia("m1")},
+			members: []string{"alice", "bob", "nobody"},
+		},
+		{
+			name:    "duplicate receipt",
+			media:   []preparedMedia{testMedia("m1")},
+			members: []string{"alice", "bob", "bob"},
+		},
+		{
+			name:    "duplicate media",
+			media:   []preparedMedia{testMedia("m1"), testMedia("m1")},
+			members: []string{"alice", "bob"},
+		},
+		{
+			name:        "attachment of a missing media",
+			media:       []preparedMedia{testMedia("m1")},
+			attachments: []database.Attachment{{Media: database.Media{Id: "missing"}}},
+			members:     []string{"alice", "bob"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, conn := newTestRouter(t)
+			_, err := rt.saveMessage("ab", "alice", "msg", "hello", tt.media, tt.attachments, "", tt.members)
+			if err == nil {
+				t.Fatal("saving the message succeeded")
+			}
+			for _, table := range []string{"messages", "read_receipts", "media", "media_variants", "message_attachments"} {
+				if n := countRows(t, conn, table); n != 0 {
+					t.Errorf("%d rows were kept in %s", n, table)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+)
+
+// Event types pushed on the /events stream.
+const (
+	eventConversationCreated = "conversation_created"
+	eventConversationUpdated = "conversation_updated"
+	eventMessageCreated      = "message_created"
+	eventMessageDeleted      = "message_deleted"
+	eventMessageEdited       = "message_edited"
+	eventMessageHidden       = "message_hidden"
+	eventReactionAdded       = "reaction_added"
+	eventReactionRemoved     = "reaction_removed"
+	eventCommentAdded        = "comment_added"
+	eventCommentEdited       = "comment_edited"
+	eventCommentDeleted      = "comment_deleted"
+	eventMessagesDelivered   = "messages_delivered"
+	eventMessagesRead        = "messages_read"
+)
+
+// eventBufferSize is how many events a subscriber may fall behind before it is disconnected.
+const eventBufferSize = 64
+
+// eventHeartbeatInterval is how often a comment is written on idle streams, so that proxies keep them open.
+const eventHeartbeatInterval = 30 * time.Second
+
+var errEventHubClosed = errors.New("event hub closed")
+
+// Event is a change in a conversation, pushed to the members of the conversation.
+type Event struct {
+	Type           string      `json:"type"`
+	ConversationID string      `json:"conversationId"`
+	Data           interface{} `json:"data,omitempty"`
+}
+
+// MessageRef identifies the message an event is about.
+type MessageRef struct {
+	MessageID string `json:"messageId"`
+	UserID    string `json:"userId,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+	CommentID string `json:"commentId,omitempty"`
+}
+
+// eventSubscriber is an open /events stream of a user.
+type eventSubscriber struct {
+	userID string
+	// sessionID is the session the stream was opened with; the stream ends when the session does.
+	sessionID string
+	events    chan Event
+}
+
+// eventHub fans events out to the open streams of the users they concern. Subscribers that do not keep up are
+// disconnected instead of blocking the publisher; clients reconnect and re-fetch.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[*eventSubscriber]struct{}
+	closed      bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[string]map[*eventSubscriber]struct{})}
+}
+
+// subscribe opens a stream for the user on behalf of one of their sessions. The events channel is closed when the
+// subscriber is dropped, its session ends or the hub is closed.
+func (h *eventHub) subscribe(userID, sessionID string) (*eventSubscriber, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errEventHubClosed
+	}
+	sub := &eventSubscriber{userID: userID, sessionID: sessionID, events: make(chan Event, eventBufferSize)}
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*eventSubscriber]struct{})
+	}
+	h.subscribers[userID][sub] = struct{}{}
+	return sub, nil
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+// remove drops the subscriber if it is still registered. The caller must hold h.mu.
+func (h *eventHub) remove(sub *eventSubscriber) {
+	subs, ok := h.subscribers[sub.userID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subscribers, sub.userID)
+	}
+	close(sub.events)
+}
+
+// publish sends the event to every open stream of the given users.
+func (h *eventHub) publish(event Event, userIDs []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		for sub := range h.subscribers[userID] {
+			select {
+			case sub.events <- event:
+			default:
+				h.remove(sub)
+			}
+		}
+	}
+}
+
+// dropSession ends the open streams of the user opened with the session, e.g. once the user logged out.
+func (h *eventHub) dropSession(userID, sessionID string) {
+	h.dropSessions(userID, func(id string) bool { return id == sessionID })
+}
+
+// dropOtherSessions ends the open streams of the user opened with any session but the one kept.
+func (h *eventHub) dropOtherSessions(userID, keepSessionID string) {
+	h.dropSessions(userID, func(id string) bool { return id != keepSessionID })
+}
+
+func (h *eventHub) dropSessions(userID string, ended func(sessionID string) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers[userID] {
+		if ended(sub.sessionID) {
+			h.remove(sub)
+		}
+	}
+}
+
+// close ends all open streams and refuses new ones.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subscribers {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// publishEvent pushes an event to the current members of the conversation and to any extra users, e.g. a member who
+// has just been removed. Failures are only logged: the change itself has already been applied.
+func (rt *_router) publishEvent(
+	ctx reqcontext.RequestContext,
+	conversationID string,
+	eventType string,
+	data interface{},
+	extraUserIDs ...string,
+) {
+	members, err := rt.db.GetConversationMembers(conversationID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch conversation members for event")
+		return
+	}
+	rt.events.publish(Event{Type: eventType, ConversationID: conversationID, Data: data}, append(members, extraUserIDs...))
+}
+
+func (rt *_router) streamEvents(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	sub, err := rt.events.subscribe(ctx.UserID, ctx.SessionID)
+	if err != nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer rt.events.unsubscribe(sub)
+
+	// The stream outlives the server write timeout.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		ctx.Logger.WithError(err).Warning("can't clear the write deadline of the event stream")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("Failed to encode event")
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
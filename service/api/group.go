@@ -3,7 +3,6 @@ package api
 import (
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -30,16 +29,13 @@ func (rt *_router) createGroup(
 		http.Error(w, "Invalid members format", http.StatusBadRequest)
 		return
 	}
-	file, _, err := r.FormFile("image")
-	if err != nil {
-		http.Error(w, "No image file provided", http.StatusBadRequest)
+	members = uniqueMembers(ctx.UserID, members)
+	photo, ok := receiveUpload(w, r, ctx, "image", photoUpload)
+	if !ok {
 		return
 	}
-	defer file.Close()
-	photo, err := io.ReadAll(file)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("Failed to read image file")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if photo == nil {
+		http.Error(w, "No image file provided", http.StatusBadRequest)
 		return
 	}
 	conversationID, err := generateNewID()
@@ -48,12 +44,13 @@ func (rt *_router) createGroup(
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	err = rt.db.CreateGroupConversation(conversationID, members, name, photo)
+	err = rt.db.CreateGroupConversation(conversationID, ctx.UserID, members, name, photo.data, photoThumbnail(ctx, photo.image))
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to create new conversation")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	rt.events.publish(Event{Type: eventConversationCreated, ConversationID: conversationID}, members)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]string{
 		"conversationId": conversationID,
@@ -68,11 +65,7 @@ func (rt *_router) getMyGroups(
 	ps httprouter.Params,
 	ctx reqcontext.RequestContext,
 ) {
-	userID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := ctx.UserID
 	conversations, err := rt.db.GetMyGroups(userID)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to fetch user's conversations")
@@ -92,9 +85,7 @@ func (rt *_router) getGroup(
 	ctx reqcontext.RequestContext,
 ) {
 	groupID := ps.ByName("groupId")
-	_, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if _, ok := rt.callerGroupRole(w, groupID, ctx); !ok {
 		return
 	}
 	group, dbErr := rt.db.GetGroupInfo(groupID)
@@ -111,6 +102,7 @@ func (rt *_router) getGroup(
 		"id":      group.Id,
 		"name":    group.Name,
 		"members": group.Members,
+		"roles":   group.Roles,
 	}
 	if group.ConversationPhoto.Valid {
 		response["groupPhoto"] = group.ConversationPhoto.String
@@ -133,12 +125,10 @@ func (rt *_router) setGroupName(
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	_, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	groupID := ps.ByName("groupId")
+	if !rt.requireGroupAdmin(w, groupID, ctx) {
 		return
 	}
-	groupID := ps.ByName("groupId")
 	var req UpdateGroupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -148,9 +138,14 @@ func (rt *_router) setGroupName(
 		http.Error(w, "Invalid group name length", http.StatusBadRequest)
 		return
 	}
-	dbErr := rt.db.UpdateGroupName(groupID, req.Name)
-	if errors.Is(dbErr, database.ErrUserDoesNotExist) {
-		http.Error(w, "User not found", http.StatusNotFound)
+	dbErr := rt.changeGroup(ctx, groupID, func(tx database.AppDatabase) ([]database.SystemEvent, error) {
+		if err := tx.UpdateGroupName(groupID, req.Name); err != nil {
+			return nil, err
+		}
+		return []database.SystemEvent{{Type: database.EventGroupRenamed, ActorId: ctx.UserID, Value: req.Name}}, nil
+	})
+	if errors.Is(dbErr, database.ErrGroupDoesNotExist) {
+		http.Error(w, "Group not found", http.StatusNotFound)
 		return
 	} else if dbErr != nil {
 		ctx.Logger.WithError(dbErr).Error("failed to update username")
@@ -172,39 +167,31 @@ func (rt *_router) setGroupPhoto(
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	_, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if !rt.requireGroupAdmin(w, groupID, ctx) {
 		return
 	}
-	err = r.ParseMultipartForm(10 * 1024 * 1024)
+	err := r.ParseMultipartForm(10 * 1024 * 1024)
 	if err != nil {
 		http.Error(w, "Failed to parse form. Ensure the file is below 10 MB.", http.StatusBadRequest)
 		return
 	}
-	file, _, err := r.FormFile("photo")
-	if err != nil {
-		http.Error(w, "Failed to retrieve photo file", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-	photoData, err := io.ReadAll(file)
-	if err != nil {
-		http.Error(w, "Failed to read photo file", http.StatusInternalServerError)
+	photo, ok := receiveUpload(w, r, ctx, "photo", photoUpload)
+	if !ok {
 		return
 	}
-	if len(photoData) > 10*1024*1024 {
-		http.Error(w, "Photo too large. Maximum allowed size is 10 MB.", http.StatusRequestEntityTooLarge)
-		return
-	}
-	fileType := http.DetectContentType(photoData)
-	if fileType != "image/jpeg" && fileType != "image/png" {
-		http.Error(w, "Invalid file type. Only JPEG and PNG are supported.", http.StatusUnsupportedMediaType)
+	if photo == nil {
+		http.Error(w, "Failed to retrieve photo file", http.StatusBadRequest)
 		return
 	}
-	err = rt.db.UpdateGroupPhoto(groupID, photoData)
-	if errors.Is(err, database.ErrUserDoesNotExist) {
-		http.Error(w, "User not found", http.StatusNotFound)
+	thumbnail := photoThumbnail(ctx, photo.image)
+	err = rt.changeGroup(ctx, groupID, func(tx database.AppDatabase) ([]database.SystemEvent, error) {
+		if err := tx.UpdateGroupPhoto(groupID, photo.data, thumbnail); err != nil {
+			return nil, err
+		}
+		return []database.SystemEvent{{Type: database.EventGroupPhoto, ActorId: ctx.UserID}}, nil
+	})
+	if errors.Is(err, database.ErrGroupDoesNotExist) {
+		http.Error(w, "Group not found", http.StatusNotFound)
 		return
 	} else if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to update user photo")
@@ -228,13 +215,28 @@ func (rt *_router) leaveGroup(
 	ctx reqcontext.RequestContext,
 ) {
 	groupID := ps.ByName("groupId")
-	userID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	userID := ctx.UserID
+	if _, ok := rt.callerGroupRole(w, groupID, ctx); !ok {
 		return
 	}
-	err = rt.db.LeaveGroup(groupID, userID)
-	if err != nil {
+	err := rt.changeGroup(ctx, groupID, func(tx database.AppDatabase) ([]database.SystemEvent, error) {
+		successorID, err := tx.LeaveGroup(groupID, userID)
+		if err != nil {
+			return nil, err
+		}
+		events := []database.SystemEvent{{Type: database.EventMemberLeft, ActorId: userID}}
+		if successorID != "" {
+			events = append(events, database.SystemEvent{Type: database.EventOwnerSucceeded, ActorId: successorID})
+		}
+		return events, nil
+	})
+	if errors.Is(err, database.ErrGroupDoesNotExist) {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrUserNotInConversation) {
+		http.Error(w, "Forbidden: You are not a member of this group", http.StatusForbidden)
+		return
+	} else if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to leave group")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -244,9 +246,7 @@ func (rt *_router) leaveGroup(
 
 func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	groupID := ps.ByName("groupId")
-	_, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if !rt.requireGroupAdmin(w, groupID, ctx) {
 		return
 	}
 	var request struct {
@@ -256,11 +256,234 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
 		return
 	}
-	err = rt.db.AddUserToGroup(groupID, request.UserID)
-	if err != nil {
+	if request.UserID == "" {
+		http.Error(w, "Missing user ID", http.StatusBadRequest)
+		return
+	}
+	err := rt.changeGroup(ctx, groupID, func(tx database.AppDatabase) ([]database.SystemEvent, error) {
+		if err := tx.AddUserToGroup(groupID, request.UserID); err != nil {
+			return nil, err
+		}
+		return []database.SystemEvent{{Type: database.EventMemberAdded, ActorId: ctx.UserID, TargetId: request.UserID}}, nil
+	})
+	if errors.Is(err, database.ErrGroupDoesNotExist) {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrUserDoesNotExist) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrUserAlreadyInConversation) {
+		http.Error(w, "User is already a member of this group", http.StatusConflict)
+		return
+	} else if err != nil {
 		ctx.Logger.WithError(err).Error("Failed to add user to group")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (rt *_router) removeFromGroup(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	groupID := ps.ByName("groupId")
+	memberID := ps.ByName("userId")
+	callerRole, ok := rt.callerGroupRole(w, groupID, ctx)
+	if !ok {
+		return
+	}
+	if !isGroupAdmin(callerRole) {
+		http.Error(w, "Forbidden: only group admins can do this", http.StatusForbidden)
+		return
+	}
+	if memberID == ctx.UserID {
+		http.Error(w, "Use the leave group operation to remove yourself", http.StatusBadRequest)
+		return
+	}
+	memberRole, err := rt.db.GetMemberRole(groupID, memberID)
+	if errors.Is(err, database.ErrUserNotInConversation) {
+		http.Error(w, "User is not a member of this group", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch member role")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if memberRole == database.RoleOwner {
+		http.Error(w, "Forbidden: the owner cannot be removed", http.StatusForbidden)
+		return
+	}
+	if memberRole == database.RoleAdmin && callerRole != database.RoleOwner {
+		http.Error(w, "Forbidden: only the owner can remove admins", http.StatusForbidden)
+		return
+	}
+	err = rt.changeGroup(ctx, groupID, func(tx database.AppDatabase) ([]database.SystemEvent, error) {
+		if err := tx.RemoveUserFromGroup(groupID, memberID); err != nil {
+			return nil, err
+		}
+		return []database.SystemEvent{{Type: database.EventMemberRemoved, ActorId: ctx.UserID, TargetId: memberID}}, nil
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to remove user from group")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rt *_router) transferGroupOwnership(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	groupID := ps.ByName("groupId")
+	callerRole, ok := rt.callerGroupRole(w, groupID, ctx)
+	if !ok {
+		return
+	}
+	if callerRole != database.RoleOwner {
+		http.Error(w, "Forbidden: only the owner can transfer the ownership", http.StatusForbidden)
+		return
+	}
+	var req TransferOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" || req.UserID == ctx.UserID {
+		http.Error(w, "Invalid new owner", http.StatusBadRequest)
+		return
+	}
+	err := rt.changeGroup(ctx, groupID, func(tx database.AppDatabase) ([]database.SystemEvent, error) {
+		if err := tx.TransferGroupOwnership(groupID, ctx.UserID, req.UserID); err != nil {
+			return nil, err
+		}
+		return []database.SystemEvent{{Type: database.EventOwnerTransferred, ActorId: ctx.UserID, TargetId: req.UserID}}, nil
+	})
+	if errors.Is(err, database.ErrUserNotInConversation) {
+		http.Error(w, "User is not a member of this group", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to transfer group ownership")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rt *_router) setMemberRole(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	groupID := ps.ByName("groupId")
+	memberID := ps.ByName("userId")
+	callerRole, ok := rt.callerGroupRole(w, groupID, ctx)
+	if !ok {
+		return
+	}
+	if !isGroupAdmin(callerRole) {
+		http.Error(w, "Forbidden: only group admins can do this", http.StatusForbidden)
+		return
+	}
+	var req UpdateMemberRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role != database.RoleAdmin && req.Role != database.RoleMember {
+		http.Error(w, "Invalid role. Allowed roles are admin and member.", http.StatusBadRequest)
+		return
+	}
+	memberRole, err := rt.db.GetMemberRole(groupID, memberID)
+	if errors.Is(err, database.ErrUserNotInConversation) {
+		http.Error(w, "User is not a member of this group", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch member role")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if memberRole == database.RoleOwner {
+		http.Error(w, "Forbidden: the owner's role cannot be changed", http.StatusForbidden)
+		return
+	}
+	if memberRole == database.RoleAdmin && callerRole != database.RoleOwner {
+		http.Error(w, "Forbidden: only the owner can demote admins", http.StatusForbidden)
+		return
+	}
+	err = rt.changeGroup(ctx, groupID, func(tx database.AppDatabase) ([]database.SystemEvent, error) {
+		if err := tx.SetMemberRole(groupID, memberID, req.Role); err != nil {
+			return nil, err
+		}
+		if req.Role == memberRole {
+			return nil, nil
+		}
+		eventType := database.EventAdminPromoted
+		if req.Role == database.RoleMember {
+			eventType = database.EventAdminDemoted
+		}
+		return []database.SystemEvent{{Type: eventType, ActorId: ctx.UserID, TargetId: memberID}}, nil
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to update member role")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callerGroupRole returns the role of the caller in the group. If the conversation is not a group or the caller is not
+// a member, the response is written and false is returned.
+func (rt *_router) callerGroupRole(w http.ResponseWriter, groupID string, ctx reqcontext.RequestContext) (string, bool) {
+	role, err := rt.db.GetMemberRole(groupID, ctx.UserID)
+	if errors.Is(err, database.ErrGroupDoesNotExist) {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return "", false
+	} else if errors.Is(err, database.ErrUserNotInConversation) {
+		http.Error(w, "Forbidden: You are not a member of this group", http.StatusForbidden)
+		return "", false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch member role")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", false
+	}
+	return role, true
+}
+
+// requireGroupAdmin checks that the caller is an admin or the owner of the group. Otherwise, the response is written
+// and false is returned.
+func (rt *_router) requireGroupAdmin(w http.ResponseWriter, groupID string, ctx reqcontext.RequestContext) bool {
+	role, ok := rt.callerGroupRole(w, groupID, ctx)
+	if !ok {
+		return false
+	}
+	if !isGroupAdmin(role) {
+		http.Error(w, "Forbidden: only group admins can do this", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func isGroupAdmin(role string) bool {
+	return role == database.RoleOwner || role == database.RoleAdmin
+}
+
+// uniqueMembers returns the member list of a new group, starting with the creator and without duplicates.
+func uniqueMembers(creatorID string, memberIDs []string) []string {
+	seen := map[string]bool{creatorID: true}
+	members := []string{creatorID}
+	for _, id := range memberIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		members = append(members, id)
+	}
+	return members
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// groupRequest runs a group handler as alice, with the path parameters given as key and value pairs.
+func groupRequest(handler httpRouterHandler, method, body string, params ...string) *httptest.ResponseRecorder {
+	var ps httprouter.Params
+	for i := 0; i+1 < len(params); i += 2 {
+		ps = append(ps, httprouter.Param{Key: params[i], Value: params[i+1]})
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, "/groups", strings.NewReader(body))
+	handler(w, r, ps, reqcontext.RequestContext{UserID: "alice", Logger: logrus.New()})
+	return w
+}
+
+func TestGroupHandlersRejectDirectConversations(t *testing.T) {
+	rt, conn := newTestRouter(t)
+	tests := []struct {
+		name    string
+		handler httpRouterHandler
+		method  string
+		body    string
+		params  []string
+	}{
+		{"get", rt.getGroup, http.MethodGet, "", nil},
+		{"leave", rt.leaveGroup, http.MethodDelete, "", nil},
+		{"add member", rt.addToGroup, http.MethodPost, `{"userId": "bob"}`, nil},
+		{"rename", rt.setGroupName, http.MethodPut, `{"name": "group"}`, nil},
+		{"remove member", rt.removeFromGroup, http.MethodDelete, "", []string{"userId", "bob"}},
+		{"transfer ownership", rt.transferGroupOwnership, http.MethodPut, `{"userId": "bob"}`, nil},
+		{"set role", rt.setMemberRole, http.MethodPut, `{"role": "admin"}`, []string{"userId", "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := groupRequest(tt.handler, tt.method, tt.body, append([]string{"groupId", "ab"}, tt.params...)...)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+	if n := countRows(t, conn, "conversation_members WHERE conversationId = 'ab' AND role = 'member'"); n != 2 {
+		t.Errorf("got %d plain members in the direct conversation, want 2", n)
+	}
+	if n := countRows(t, conn, "messages"); n != 0 {
+		t.Errorf("%d system messages were written to the direct conversation", n)
+	}
+}
+
+func TestAddToGroup(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantAdded  bool
+	}{
+		{"new member", `{"userId": "carol"}`, http.StatusNoContent, true},
+		{"no user", `{}`, http.StatusBadRequest, false},
+		{"unknown user", `{"userId": "nobody"}`, http.StatusNotFound, false},
+		{"already a member", `{"userId": "bob"}`, http.StatusConflict, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, conn := newTestRouter(t)
+			if _, err := rt.db.CreateUser(database.User{Id: "carol", Name: "carol"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := rt.db.CreateGroupConversation("g", "alice", []string{"alice", "bob"}, "group", nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			w := groupRequest(rt.addToGroup, http.MethodPost, tt.body, "groupId", "g")
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			wantMembers, wantMessages := 2, 0
+			if tt.wantAdded {
+				wantMembers, wantMessages = 3, 1
+			}
+			if n := countRows(t, conn, "conversation_members WHERE conversationId = 'g'"); n != wantMembers {
+				t.Errorf("got %d members, want %d", n, wantMembers)
+			}
+			if n := countRows(t, conn, "messages"); n != wantMessages {
+				t.Errorf("got %d system messages, want %d", n, wantMessages)
+			}
+		})
+	}
+}
+
+func TestLeaveGroup(t *testing.T) {
+	rt, conn := newTestRouter(t)
+	if err := rt.db.CreateGroupConversation("g", "alice", []string{"alice", "bob"}, "group", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w := groupRequest(rt.leaveGroup, http.MethodDelete, "", "groupId", "g"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if n := countRows(t, conn, "conversation_members WHERE conversationId = 'g' AND userId = 'bob' AND role = 'owner'"); n != 1 {
+		t.Error("the remaining member did not become the owner")
+	}
+	// Leaving twice finds the caller no longer a member.
+	if w := groupRequest(rt.leaveGroup, http.MethodDelete, "", "groupId", "g"); w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := groupRequest(rt.leaveGroup, http.MethodDelete, "", "groupId", "missing"); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a missing group, want %d", w.Code, http.StatusNotFound)
+	}
+}
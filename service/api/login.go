@@ -31,33 +31,80 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 		http.Error(w, "Invalid photo data", http.StatusBadRequest)
 		return
 	}
+	if req.Password != "" && (len(req.Password) < minPasswordLength || len(req.Password) > maxPasswordLength) {
+		http.Error(w, "Invalid password length", http.StatusBadRequest)
+		return
+	}
 	user, err := rt.db.GetUserByName(req.Name)
 	if errors.Is(err, database.ErrUserDoesNotExist) {
+		if req.Password == "" && !rt.allowPasswordlessLogin {
+			http.Error(w, "Password is required", http.StatusBadRequest)
+			return
+		}
+		newUser := database.User{Name: req.Name}
+		if len(photoBytes) > 0 {
+			photo := checkUpload(w, ctx, photoBytes, photoUpload)
+			if photo == nil {
+				return
+			}
+			newUser.Photo, newUser.PhotoThumb = photo.data, photoThumbnail(ctx, photo.image)
+		}
 		newID, genErr := generateNewID()
 		if genErr != nil {
 			ctx.Logger.WithError(genErr).Error("Failed to generate user ID")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		newUser := database.User{
-			Id:    newID,
-			Name:  req.Name,
-			Photo: photoBytes,
+		newUser.Id = newID
+		var passwordHash string
+		if req.Password != "" {
+			var hashErr error
+			passwordHash, hashErr = hashPassword(req.Password)
+			if hashErr != nil {
+				ctx.Logger.WithError(hashErr).Error("cannot hash password")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
 		}
-		createdUser, createErr := rt.db.CreateUser(newUser)
+		// The password is stored along with the account, so that no login ever finds the account without it.
+		var createdUser database.User
+		createErr := rt.db.WithTx(func(tx database.AppDatabase) error {
+			var err error
+			createdUser, err = tx.CreateUser(newUser)
+			if err != nil || createdUser.Id != newID || passwordHash == "" {
+				return err
+			}
+			return tx.SetUserPasswordHash(createdUser.Id, passwordHash)
+		})
 		if createErr != nil {
 			ctx.Logger.WithError(createErr).Error("cannot create user")
 			http.Error(w, "Internal Server Error: cannot create user", http.StatusInternalServerError)
 			return
 		}
+		if createdUser.Id != newID {
+			// Someone registered the same name concurrently: log into that account with the usual checks.
+			if !rt.checkLoginPassword(w, createdUser.Id, req.Password, ctx) {
+				return
+			}
+		}
 		user = createdUser
 	} else if err != nil {
 		ctx.Logger.WithError(err).Error("error retrieving user")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
+	} else if !rt.checkLoginPassword(w, user.Id, req.Password, ctx) {
+		return
+	}
+	token, session, err := rt.createSession(user.Id)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot create session")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 	resp := LoginResponse{
-		Identifier: user.Id,
+		Identifier: token,
+		UserID:     user.Id,
+		ExpiresAt:  session.ExpiresAt,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -66,3 +113,33 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 }
+
+// checkLoginPassword verifies the password given at login against the one stored for the user. Accounts without a
+// password are accepted only when passwordless login is enabled. If the check fails, the response is written and false
+// is returned.
+func (rt *_router) checkLoginPassword(w http.ResponseWriter, userID, password string, ctx reqcontext.RequestContext) bool {
+	passwordHash, err := rt.db.GetUserPasswordHash(userID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error retrieving password")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if passwordHash == "" {
+		if !rt.allowPasswordlessLogin {
+			http.Error(w, "Unauthorized: this account has no password", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	ok, err := checkPassword(password, passwordHash)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("stored password hash is invalid")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Unauthorized: invalid name or password", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDoLoginCreatesAccountWithPassword(t *testing.T) {
+	rt, _ := newTestRouter(t)
+	login := func(body string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/session", strings.NewReader(body))
+		rt.doLogin(w, r, nil, reqcontext.RequestContext{Logger: logrus.New()})
+		return w.Code
+	}
+	if code := login(`{"name": "carol", "password": "carol password"}`); code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", code, http.StatusCreated)
+	}
+	user, err := rt.db.GetUserByName("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := rt.db.GetUserPasswordHash(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := checkPassword("carol password", stored); err != nil || !ok {
+		t.Errorf("the account was created without its password: %q", stored)
+	}
+	if code := login(`{"name": "carol"}`); code != http.StatusUnauthorized {
+		t.Errorf("got status %d for a login without the password, want %d", code, http.StatusUnauthorized)
+	}
+}
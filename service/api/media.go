@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+	"github.com/nazerke1234/wasa/service/imaging"
+)
+
+// preparedMedia is an upload ready to be saved: its metadata, its content and the thumbnails of images.
+type preparedMedia struct {
+	media    database.Media
+	data     []byte
+	variants []database.MediaVariant
+}
+
+// prepareMedia builds the metadata and the thumbnails of an upload of the caller. Thumbnails are built ahead, so that
+// the transaction saving the media does not wait for them.
+func prepareMedia(ctx reqcontext.RequestContext, u *upload) (preparedMedia, error) {
+	mediaID, err := generateNewID()
+	if err != nil {
+		return preparedMedia{}, err
+	}
+	sum := sha256.Sum256(u.data)
+	media := database.Media{
+		Id:         mediaID,
+		FileName:   u.fileName,
+		MimeType:   u.mimeType,
+		Size:       int64(len(u.data)),
+		Sha256:     hex.EncodeToString(sum[:]),
+		UploaderId: ctx.UserID,
+		CreatedAt:  time.Now().UTC().Format(database.MessageTimestampFormat),
+	}
+	if u.image != nil {
+		media.Width, media.Height = u.image.Width, u.image.Height
+	}
+	prepared := preparedMedia{media: media, data: u.data}
+	if u.image != nil {
+		thumbs, err := u.image.Thumbnails(imaging.Sizes...)
+		if err != nil {
+			// The original is stored; clients fall back to it.
+			ctx.Logger.WithError(err).Warning("can't build thumbnails of an attachment")
+		}
+		for _, thumb := range thumbs {
+			prepared.variants = append(prepared.variants, database.MediaVariant{
+				Name:     thumb.Size.Name,
+				MimeType: thumb.MimeType,
+				Width:    thumb.Width,
+				Height:   thumb.Height,
+				Data:     thumb.Data,
+			})
+		}
+	}
+	return prepared, nil
+}
+
+// saveMedia saves a prepared media along with its thumbnails.
+func saveMedia(db database.AppDatabase, m preparedMedia) error {
+	if err := db.SaveMedia(m.media, m.data); err != nil {
+		return err
+	}
+	for _, variant := range m.variants {
+		if err := db.SaveMediaVariant(m.media.Id, variant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// photoThumbnail returns the small variant of a profile or group photo, or nil when the photo is small enough to be
+// listed as is.
+func photoThumbnail(ctx reqcontext.RequestContext, photo *imaging.Image) []byte {
+	thumbs, err := photo.Thumbnails(imaging.Small)
+	if err != nil {
+		ctx.Logger.WithError(err).Warning("can't build the thumbnail of a photo")
+		return nil
+	}
+	if len(thumbs) == 0 {
+		return nil
+	}
+	return thumbs[0].Data
+}
+
+// getMedia serves the content of an attachment to the members of a conversation where it was sent, under its original
+// file name. Images are shown inline unless the download query parameter is set; other files are always downloaded,
+// so that nothing uploaded is ever rendered by the browser as a page of ours. Media never change, so clients may cache
+// them for good; ranges and conditional requests are supported.
+func (rt *_router) getMedia(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	media, ok := rt.accessibleMedia(w, ps.ByName("mediaId"), ctx)
+	if !ok {
+		return
+	}
+	content, err := rt.db.GetMediaContent(media.Id)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch media content")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	download, _ := strconv.ParseBool(r.URL.Query().Get("download"))
+	disposition := "attachment"
+	if imageMimeTypes[media.MimeType] && !download {
+		disposition = "inline"
+	}
+	if media.FileName != "" {
+		if value := mime.FormatMediaType(disposition, map[string]string{"filename": media.FileName}); value != "" {
+			disposition = value
+		}
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	serveMedia(w, r, media, media.MimeType, media.Sha256, content)
+}
+
+// getMediaVariant serves a thumbnail of an image attachment. When the original is already small enough, the original
+// is served instead, so clients can always ask for the size they display. Other files have no thumbnails.
+func (rt *_router) getMediaVariant(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	size, ok := imaging.SizeByName(ps.ByName("variant"))
+	if !ok {
+		http.Error(w, "Unknown media variant", http.StatusNotFound)
+		return
+	}
+	media, ok := rt.accessibleMedia(w, ps.ByName("mediaId"), ctx)
+	if !ok {
+		return
+	}
+	if !imageMimeTypes[media.MimeType] {
+		http.Error(w, "Only images have thumbnails", http.StatusNotFound)
+		return
+	}
+	variant, err := rt.db.GetMediaVariant(media.Id, size.Name)
+	if errors.Is(err, database.ErrMediaDoesNotExist) {
+		content, err := rt.db.GetMediaContent(media.Id)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to fetch media content")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		serveMedia(w, r, media, media.MimeType, media.Sha256, content)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch media variant")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	serveMedia(w, r, media, variant.MimeType, media.Sha256+"-"+size.Name, variant.Data)
+}
+
+// accessibleMedia returns the metadata of a media the caller may see. Otherwise an error response is written and
+// false returned.
+func (rt *_router) accessibleMedia(w http.ResponseWriter, mediaID string, ctx reqcontext.RequestContext) (database.Media, bool) {
+	allowed, err := rt.db.CanUserAccessMedia(mediaID, ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check media access")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return database.Media{}, false
+	}
+	if !allowed {
+		// Unknown media and media of other conversations look the same.
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return database.Media{}, false
+	}
+	media, err := rt.db.GetMedia(mediaID)
+	if errors.Is(err, database.ErrMediaDoesNotExist) {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return database.Media{}, false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch media")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return database.Media{}, false
+	}
+	return media, true
+}
+
+func serveMedia(w http.ResponseWriter, r *http.Request, media database.Media, mimeType, etag string, content []byte) {
+	createdAt, _ := time.Parse(database.MessageTimestampFormat, media.CreatedAt)
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", createdAt, bytes.NewReader(content))
+}
+
+// getConversationPhoto serves the full size photo of a conversation to its members; listings only carry the small
+// variant. The photo of a direct conversation is the one of the other member.
+func (rt *_router) getConversationPhoto(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	conversationID := ps.ByName("conversationId")
+	isMember, err := rt.db.IsUserInConversation(conversationID, ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	photo, err := rt.db.GetConversationPhoto(conversationID, ctx.UserID)
+	if errors.Is(err, database.ErrPhotoDoesNotExist) || errors.Is(err, database.ErrConversationDoesNotExist) {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch conversation photo")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Photos can be replaced, so clients revalidate them through the ETag.
+	sum := sha256.Sum256(photo)
+	w.Header().Set("Content-Type", http.DetectContentType(photo))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(photo))
+}
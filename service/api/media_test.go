@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMediaTimes(t *testing.T) {
+	ctx := reqcontext.RequestContext{UserID: "alice", Logger: logrus.New()}
+	before := time.Now().UTC().Truncate(time.Millisecond)
+	prepared, err := prepareMedia(ctx, &upload{data: []byte("notes"), fileName: "notes.txt", mimeType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Media times sort along with message times.
+	createdAt, err := time.Parse(database.MessageTimestampFormat, prepared.media.CreatedAt)
+	if err != nil {
+		t.Fatalf("the upload time %q is not a message timestamp: %v", prepared.media.CreatedAt, err)
+	}
+	if createdAt.Before(before) {
+		t.Errorf("got upload time %v, before %v", createdAt, before)
+	}
+
+	w := httptest.NewRecorder()
+	serveMedia(w, httptest.NewRequest(http.MethodGet, "/media/m", nil), prepared.media, "text/plain", "etag", prepared.data)
+	if got := w.Header().Get("Last-Modified"); got != createdAt.Format(http.TimeFormat) {
+		t.Errorf("got Last-Modified %q, want %q", got, createdAt.Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 200
+)
+
+// encodeMessageCursor returns the opaque cursor pointing at the message.
+func encodeMessageCursor(message database.Message) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(message.Timestamp + "|" + message.Id))
+}
+
+func decodeMessageCursor(cursor string) (*database.MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("malformed cursor")
+	}
+	return &database.MessageCursor{Timestamp: parts[0], Id: parts[1]}, nil
+}
+
+// pageCursors returns the cursors to fetch the messages before and after the page, empty when there are none.
+func pageCursors(page database.MessagePage) (string, string) {
+	var prev, next string
+	if page.HasOlder {
+		prev = encodeMessageCursor(page.Messages[0])
+	}
+	if page.HasNewer {
+		next = encodeMessageCursor(page.Messages[len(page.Messages)-1])
+	}
+	return prev, next
+}
+
+// parseMessageLimit reads the limit query parameter. On error, a 400 response is written.
+func parseMessageLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultMessagePageSize, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxMessagePageSize {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+// parseMessageQuery reads the before, after and limit query parameters. On error, a 400 response is written.
+func parseMessageQuery(w http.ResponseWriter, r *http.Request) (database.MessageQuery, bool) {
+	var query database.MessageQuery
+	limit, ok := parseMessageLimit(w, r)
+	if !ok {
+		return query, false
+	}
+	query.Limit = limit
+	before, after := r.URL.Query().Get("before"), r.URL.Query().Get("after")
+	if before != "" && after != "" {
+		http.Error(w, "Only one of before and after can be given", http.StatusBadRequest)
+		return query, false
+	}
+	var err error
+	if before != "" {
+		query.Before, err = decodeMessageCursor(before)
+	} else if after != "" {
+		query.After, err = decodeMessageCursor(after)
+	}
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return query, false
+	}
+	return query, true
+}
+
+// getMessagesAround returns the messages around a message, e.g. to jump to the message a reply points to.
+func (rt *_router) getMessagesAround(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	conversationID := ps.ByName("conversationId")
+	isMember, err := rt.db.IsUserInConversation(conversationID, ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		return
+	}
+	limit, ok := parseMessageLimit(w, r)
+	if !ok {
+		return
+	}
+	page, err := rt.db.GetMessagesAround(conversationID, ps.ByName("messageId"), ctx.UserID, limit)
+	if errors.Is(err, database.ErrMessageDoesNotExist) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch messages around message")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	response := MessagePageResponse{Messages: page.Messages}
+	response.PrevCursor, response.NextCursor = pageCursors(page)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode messages")
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// The vectors of RFC 7914, section 11, and the SHA-256 counterparts of the RFC 6070 vectors.
+	tests := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{
+			"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096,
+			"348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9",
+		},
+		{"pass\x00word", "sa\x00lt", 4096, "89b69d0516f829893c696226650a8687"},
+		{
+			"passwd", "salt", 1,
+			"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+				"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+		{
+			"Password", "NaCl", 80000,
+			"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+				"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.want)
+		got := pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, len(want))
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("%q %q %d: got %x, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	encoded, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, passwordAlgorithm+"$600000$") {
+		t.Errorf("got hash %q", encoded)
+	}
+	other, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == encoded {
+		t.Error("two hashes of the same password share their salt")
+	}
+	if ok, err := checkPassword("correct horse", encoded); err != nil || !ok {
+		t.Errorf("the password does not match its hash: %v", err)
+	}
+	if ok, err := checkPassword("wrong horse", encoded); err != nil || ok {
+		t.Errorf("a wrong password matches the hash: %v", err)
+	}
+}
+
+func TestCheckPasswordRejectsMalformedHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"other algorithm", "bcrypt$10$c2FsdA$a2V5"},
+		{"missing key", "pbkdf2-sha256$1$c2FsdA"},
+		{"extra field", "pbkdf2-sha256$1$c2FsdA$a2V5$a2V5"},
+		{"iterations not a number", "pbkdf2-sha256$many$c2FsdA$a2V5"},
+		{"no iterations", "pbkdf2-sha256$0$c2FsdA$a2V5"},
+		{"negative iterations", "pbkdf2-sha256$-1$c2FsdA$a2V5"},
+		{"salt not base64", "pbkdf2-sha256$1$!!$a2V5"},
+		{"key not base64", "pbkdf2-sha256$1$c2FsdA$!!"},
+		{"empty key", "pbkdf2-sha256$1$c2FsdA$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := checkPassword("password", tt.encoded)
+			if ok || !errors.Is(err, ErrInvalidPasswordHash) {
+				t.Errorf("got %v, %v, want %v", ok, err, ErrInvalidPasswordHash)
+			}
+		})
+	}
+}
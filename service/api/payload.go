@@ -1,14 +1,33 @@
 package api
 
-import "time"
+import (
+	"time"
+
+	"github.com/nazerke1234/wasa/service/database"
+)
 
 type LoginRequest struct {
-	Name  string `json:"name"`
-	Photo string `json:"photo"`
+	Name     string `json:"name"`
+	Photo    string `json:"photo"`
+	Password string `json:"password"`
 }
 
 type LoginResponse struct {
 	Identifier string `json:"identifier"`
+	UserID     string `json:"userId"`
+	ExpiresAt  string `json:"expiresAt"`
+}
+
+type Session struct {
+	ID        string `json:"id"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+	Current   bool   `json:"current"`
+}
+
+type UpdatePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
 }
 
 type UpdateUserRequest struct {
@@ -19,6 +38,38 @@ type UpdateGroupRequest struct {
 	Name string `json:"groupName"`
 }
 
+type MessagePageResponse struct {
+	Messages   []database.Message `json:"messages"`
+	PrevCursor string             `json:"prevCursor,omitempty"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+type MessageSearchResponse struct {
+	Results    []database.MessageMatch `json:"results"`
+	NextCursor string                  `json:"nextCursor,omitempty"`
+}
+
+type AcknowledgeRequest struct {
+	MessageID string `json:"messageId"`
+}
+
+type CommentRequest struct {
+	Content string `json:"content"`
+}
+
+type CommentPageResponse struct {
+	Comments   []database.Comment `json:"comments"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type TransferOwnershipRequest struct {
+	UserID string `json:"userId"`
+}
+
 type User struct {
 	ID    string `json:"id"`
 	Name  string `json:"name"`
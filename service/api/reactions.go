@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+// maxEmojiLength is the longest reaction accepted, in bytes. Emoji built from several code points, like flags, skin
+// tones or families, fit well within it.
+const maxEmojiLength = 32
+
+// validEmoji reports whether the reaction looks like a single emoji: a short sequence of symbols and of the marks,
+// joiners and selectors emoji are built with, without letters, spaces or control characters.
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	symbol := false
+	for _, r := range emoji {
+		switch {
+		case unicode.IsLetter(r), unicode.IsSpace(r), unicode.IsControl(r):
+			return false
+		case unicode.Is(unicode.So, r), r == '\u20e3':
+			// Pictographs, regional indicators for flags, and the keycap of digit emoji.
+			symbol = true
+		}
+	}
+	return symbol
+}
+
+func (rt *_router) addReaction(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	rt.changeReaction(w, ps, ctx, eventReactionAdded)
+}
+
+func (rt *_router) removeReaction(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	rt.changeReaction(w, ps, ctx, eventReactionRemoved)
+}
+
+// changeReaction adds or removes the reaction of the caller, according to the event type, and tells the members of
+// the conversation.
+func (rt *_router) changeReaction(
+	w http.ResponseWriter,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+	eventType string,
+) {
+	conversationID := ps.ByName("conversationId")
+	messageID := ps.ByName("messageId")
+	emoji := ps.ByName("emoji")
+	if !validEmoji(emoji) {
+		http.Error(w, "The reaction must be a single emoji", http.StatusBadRequest)
+		return
+	}
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		return
+	}
+	var err error
+	if eventType == eventReactionAdded {
+		err = rt.db.AddReaction(conversationID, messageID, ctx.UserID, emoji)
+	} else {
+		err = rt.db.RemoveReaction(conversationID, messageID, ctx.UserID, emoji)
+	}
+	if errors.Is(err, database.ErrMessageDoesNotExist) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrMessageDeleted) {
+		http.Error(w, "Forbidden: deleted messages cannot get reactions", http.StatusForbidden)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to change reaction")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.publishEvent(ctx, conversationID, eventType, MessageRef{MessageID: messageID, UserID: ctx.UserID, Emoji: emoji})
+	w.WriteHeader(http.StatusNoContent)
+}
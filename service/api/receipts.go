@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+// getMessageReceipts tells the sender of a message when it was delivered to and read by each recipient.
+func (rt *_router) getMessageReceipts(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	conversationID := ps.ByName("conversationId")
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		return
+	}
+	receipts, err := rt.db.GetMessageReceipts(conversationID, ps.ByName("messageId"), ctx.UserID)
+	if errors.Is(err, database.ErrMessageDoesNotExist) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrUnauthorizedToViewReceipts) {
+		http.Error(w, "Forbidden: You are not the sender of this message", http.StatusForbidden)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch receipts")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(receipts); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode receipts")
+	}
+}
+
+func (rt *_router) acknowledgeDelivery(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	rt.acknowledge(w, r, ps, ctx, eventMessagesDelivered)
+}
+
+func (rt *_router) acknowledgeRead(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	rt.acknowledge(w, r, ps, ctx, eventMessagesRead)
+}
+
+// acknowledge records that the caller received, or read, according to the event type, the messages of the
+// conversation up to the one in the request, and tells the members of the conversation if any was new to them.
+func (rt *_router) acknowledge(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+	eventType string,
+) {
+	conversationID := ps.ByName("conversationId")
+	var req AcknowledgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+		return
+	}
+	var changed bool
+	var err error
+	if eventType == eventMessagesDelivered {
+		changed, err = rt.db.MarkMessagesDelivered(conversationID, ctx.UserID, req.MessageID)
+	} else {
+		changed, err = rt.db.MarkMessagesRead(conversationID, ctx.UserID, req.MessageID)
+	}
+	if errors.Is(err, database.ErrMessageDoesNotExist) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to acknowledge messages")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if changed {
+		rt.publishEvent(ctx, conversationID, eventType, MessageRef{MessageID: req.MessageID, UserID: ctx.UserID})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUnreadCount returns how many messages the caller has not read, e.g. for a badge on the app icon.
+func (rt *_router) getUnreadCount(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	count, err := rt.db.GetUnreadCount(ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to count unread messages")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(count); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode unread count")
+	}
+}
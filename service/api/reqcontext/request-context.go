@@ -18,4 +18,10 @@ type RequestContext struct {
 
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// UserID is the ID of the authenticated caller. It is empty for public routes.
+	UserID string
+
+	// SessionID is the ID of the session used to authenticate the caller. It is empty for public routes.
+	SessionID string
 }
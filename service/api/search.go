@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+const (
+	// maxSearchLength is the longest search text accepted, in bytes.
+	maxSearchLength = 200
+	// maxSearchTerms is how many words a search can have.
+	maxSearchTerms = 10
+	// searchDateFormat is the format of the dates bounding a search, when no time of day is given.
+	searchDateFormat = "2006-01-02"
+)
+
+// encodeSearchCursor returns the opaque cursor pointing at the matching message. It is a message cursor, so that
+// decodeMessageCursor reads it back.
+func encodeSearchCursor(match database.MessageMatch) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(match.Timestamp + "|" + match.MessageId))
+}
+
+// parseSearchBound reads a date or a RFC 3339 time bounding a search, both bounds being inclusive. A date stands for
+// the whole day. The bound is returned in the format of message timestamps; an upper one is made exclusive.
+func parseSearchBound(value string, upper bool) (string, bool) {
+	if t, err := time.Parse(searchDateFormat, value); err == nil {
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t.UTC().Format(database.MessageTimestampFormat), true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", false
+	}
+	if upper {
+		t = t.Add(time.Millisecond)
+	}
+	return t.UTC().Format(database.MessageTimestampFormat), true
+}
+
+// searchMessages finds the messages of the caller's conversations containing all the words of the search.
+func (rt *_router) searchMessages(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	params := r.URL.Query()
+	text := params.Get("q")
+	if len(text) > maxSearchLength {
+		http.Error(w, "The search is too long", http.StatusBadRequest)
+		return
+	}
+	terms := strings.Fields(text)
+	if len(terms) == 0 {
+		http.Error(w, "Missing 'q' query parameter", http.StatusBadRequest)
+		return
+	}
+	if len(terms) > maxSearchTerms {
+		http.Error(w, "The search has too many words", http.StatusBadRequest)
+		return
+	}
+	limit, ok := parseMessageLimit(w, r)
+	if !ok {
+		return
+	}
+	query := database.MessageSearch{UserID: ctx.UserID, Terms: terms, Limit: limit}
+	if from := params.Get("from"); from != "" {
+		if query.From, ok = parseSearchBound(from, false); !ok {
+			http.Error(w, "Invalid 'from' date", http.StatusBadRequest)
+			return
+		}
+	}
+	if to := params.Get("to"); to != "" {
+		if query.Until, ok = parseSearchBound(to, true); !ok {
+			http.Error(w, "Invalid 'to' date", http.StatusBadRequest)
+			return
+		}
+	}
+	if before := params.Get("before"); before != "" {
+		var err error
+		if query.Before, err = decodeMessageCursor(before); err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	if conversationID := params.Get("conversationId"); conversationID != "" {
+		if ok, err := rt.db.IsUserInConversation(conversationID, ctx.UserID); !ok {
+			if err != nil {
+				ctx.Logger.WithError(err).Error("Failed to check conversation membership")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, "Forbidden: You are not a member of this conversation", http.StatusForbidden)
+			return
+		}
+		query.ConversationID = conversationID
+	}
+	page, err := rt.db.SearchMessages(query)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to search messages")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	response := MessageSearchResponse{Results: page.Matches}
+	if page.HasMore {
+		response.NextCursor = encodeSearchCursor(page.Matches[len(page.Matches)-1])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode search results")
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+var ErrUnauthorized = errors.New("unauthorized request")
+
+// ErrEmptyMessage is returned when a message has neither content nor attachment.
+var ErrEmptyMessage = errors.New("message content or attachment is required")
+
+// sessionTokenBytes is the amount of random bytes in a session token.
+const sessionTokenBytes = 32
+
+// hashSessionToken returns the value stored in the database for a session token. Tokens are never stored in clear, so
+// a leaked database does not allow impersonating users.
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession creates a new session for the user and returns the token the client must send in the Authorization
+// header.
+func (rt *_router) createSession(userID string) (string, database.Session, error) {
+	raw := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", database.Session{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	sessionID, err := generateNewID()
+	if err != nil {
+		return "", database.Session{}, err
+	}
+	now := time.Now().UTC()
+	session := database.Session{
+		Id:        sessionID,
+		UserId:    userID,
+		TokenHash: hashSessionToken(token),
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(rt.sessionTTL).Format(time.RFC3339),
+	}
+	if err := rt.db.CreateSession(session); err != nil {
+		return "", database.Session{}, err
+	}
+	return token, session, nil
+}
+
+// bearerToken returns the token of the Authorization header, or an empty string if there is none.
+func bearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
+	}
+	return authHeader[7:]
+}
+
+// getAuthenticatedSession resolves a session token to an active session. Expired sessions are removed and reported as
+// unauthorized.
+func (rt *_router) getAuthenticatedSession(token string) (database.Session, error) {
+	if token == "" {
+		return database.Session{}, ErrUnauthorized
+	}
+	session, err := rt.db.GetSessionByTokenHash(hashSessionToken(token))
+	if errors.Is(err, database.ErrSessionDoesNotExist) {
+		return database.Session{}, ErrUnauthorized
+	} else if err != nil {
+		return database.Session{}, err
+	}
+	expiresAt, err := time.Parse(time.RFC3339, session.ExpiresAt)
+	if err != nil || !time.Now().Before(expiresAt) {
+		_ = rt.db.DeleteSession(session.Id, session.UserId)
+		return database.Session{}, ErrUnauthorized
+	}
+	return session, nil
+}
+
+func (rt *_router) doLogout(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	if err := rt.db.DeleteSession(ctx.SessionID, ctx.UserID); err != nil && !errors.Is(err, database.ErrSessionDoesNotExist) {
+		ctx.Logger.WithError(err).Error("Failed to delete session")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.events.dropSession(ctx.UserID, ctx.SessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rt *_router) getMySessions(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	sessions, err := rt.db.GetUserSessions(ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch sessions")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	response := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		response = append(response, Session{
+			ID:        s.Id,
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+			Current:   s.Id == ctx.SessionID,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to encode sessions")
+	}
+}
+
+func (rt *_router) revokeSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	sessionID := ps.ByName("sessionId")
+	err := rt.db.DeleteSession(sessionID, ctx.UserID)
+	if errors.Is(err, database.ErrSessionDoesNotExist) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to revoke session")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.events.dropSession(ctx.UserID, sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+)
+
+// changeGroup applies a change to the group and records the system events it returns in the history of the
+// conversation, in one transaction: a change is never left unrecorded, nor an event recorded for a change that
+// failed. The events are pushed to the members once committed.
+func (rt *_router) changeGroup(
+	ctx reqcontext.RequestContext,
+	groupID string,
+	change func(tx database.AppDatabase) ([]database.SystemEvent, error),
+) error {
+	var messages []database.Message
+	err := rt.db.WithTx(func(tx database.AppDatabase) error {
+		events, err := change(tx)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			message, err := saveSystemMessage(tx, groupID, event)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		event := message.Event
+		rt.publishEvent(ctx, groupID, eventMessageCreated, message)
+		// Members who have just left or been removed are told too, so they can drop the conversation.
+		rt.publishEvent(ctx, groupID, eventConversationUpdated, *event, event.ActorId, event.TargetId)
+	}
+	return nil
+}
+
+// saveSystemMessage records a change made by the actor of the event in the history of the conversation.
+func saveSystemMessage(db database.AppDatabase, conversationID string, event database.SystemEvent) (database.Message, error) {
+	messageID, err := generateNewID()
+	if err != nil {
+		return database.Message{}, fmt.Errorf("generating system message ID: %w", err)
+	}
+	event.ActorName = userName(db, event.ActorId)
+	if event.TargetId != "" {
+		event.TargetName = userName(db, event.TargetId)
+	}
+	return db.SaveSystemMessage(conversationID, messageID, describeSystemEvent(event), event)
+}
+
+// describeSystemEvent returns the English text stored as content of a system message, for clients that do not render
+// the structured event.
+func describeSystemEvent(event database.SystemEvent) string {
+	switch event.Type {
+	case database.EventMemberAdded:
+		return event.ActorName + " added " + event.TargetName
+	case database.EventMemberLeft:
+		return event.ActorName + " left the group"
+	case database.EventMemberRemoved:
+		return event.ActorName + " removed " + event.TargetName
+	case database.EventAdminPromoted:
+		return event.ActorName + " made " + event.TargetName + " an admin"
+	case database.EventAdminDemoted:
+		return event.ActorName + " removed " + event.TargetName + " as admin"
+	case database.EventOwnerTransferred:
+		return event.ActorName + " made " + event.TargetName + " the owner of the group"
+	case database.EventOwnerSucceeded:
+		return event.ActorName + " is now the owner of the group"
+	case database.EventGroupRenamed:
+		return event.ActorName + " renamed the group to " + event.Value
+	case database.EventGroupPhoto:
+		return event.ActorName + " changed the group photo"
+	default:
+		return event.ActorName + " changed the conversation"
+	}
+}
+
+// userName returns the name of the user, or a placeholder if it cannot be fetched.
+func userName(db database.AppDatabase, userID string) string {
+	user, err := db.GetUserById(userID)
+	if err != nil {
+		return "Someone"
+	}
+	return user.Name
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+func renameGroup(rt *_router, actorID string) error {
+	ctx := reqcontext.RequestContext{UserID: "alice", Logger: logrus.New()}
+	return rt.changeGroup(ctx, "g", func(tx database.AppDatabase) ([]database.SystemEvent, error) {
+		if err := tx.UpdateGroupName("g", "renamed"); err != nil {
+			return nil, err
+		}
+		return []database.SystemEvent{{Type: database.EventGroupRenamed, ActorId: actorID, Value: "renamed"}}, nil
+	})
+}
+
+func TestChangeGroup(t *testing.T) {
+	rt, conn := newTestRouter(t)
+	if err := rt.db.CreateGroupConversation("g", "alice", []string{"alice", "bob"}, "group", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := renameGroup(rt, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := conn.QueryRow(`SELECT name FROM conversations WHERE id = 'g'`).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "renamed" {
+		t.Errorf("got name %q, want %q", name, "renamed")
+	}
+	if n := countRows(t, conn, "messages"); n != 1 {
+		t.Errorf("got %d system messages, want 1", n)
+	}
+}
+
+func TestChangeGroupRollsBack(t *testing.T) {
+	rt, conn := newTestRouter(t)
+	if err := rt.db.CreateGroupConversation("g", "alice", []string{"alice", "bob"}, "group", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The group is renamed, then the system message fails on its missing actor.
+	if err := renameGroup(rt, "nobody"); err == nil {
+		t.Fatal("changing the group succeeded")
+	}
+	var name string
+	if err := conn.QueryRow(`SELECT name FROM conversations WHERE id = 'g'`).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "group" {
+		t.Errorf("the group was renamed to %q", name)
+	}
+	if n := countRows(t, conn, "messages"); n != 0 {
+		t.Errorf("%d system messages were kept", n)
+	}
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/nazerke1234/wasa/service/imaging"
+)
+
+// upload is a file received from a client, validated but not stored yet.
+type upload struct {
+	data []byte
+	// fileName is the name of the file on the client, if it was sent as a file.
+	fileName string
+	// mimeType is sniffed from the content; the type claimed by the client is never trusted.
+	mimeType string
+	// image is the sanitized image, for image uploads.
+	image *imaging.Image
+}
+
+// uploadKind describes what an upload endpoint accepts. Types are MIME types, or patterns like "audio/*"; denied
+// types win over allowed ones.
+type uploadKind struct {
+	name    string
+	maxSize int64
+	allowed []string
+	denied  []string
+}
+
+// photoUpload is a profile or group photo. Attachments are configured, see Config.
+var photoUpload = uploadKind{
+	name:    "photo",
+	maxSize: 10 << 20,
+	allowed: []string{"image/jpeg", "image/png"},
+}
+
+// accepts reports whether a file of the given MIME type, without parameters, may be uploaded.
+func (k uploadKind) accepts(mimeType string) bool {
+	return matchMimeType(k.allowed, mimeType) && !matchMimeType(k.denied, mimeType)
+}
+
+func matchMimeType(patterns []string, mimeType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*/*" || pattern == mimeType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxFileNameLength is the longest file name kept with an attachment, in bytes.
+const maxFileNameLength = 255
+
+// imageMimeTypes are the types that go through the image pipeline.
+var imageMimeTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// receiveUpload reads and validates the file of a multipart form field. A nil upload and true are returned when the
+// field is missing. If the file is rejected, an error response is written and false returned.
+func receiveUpload(
+	w http.ResponseWriter,
+	r *http.Request,
+	ctx reqcontext.RequestContext,
+	field string,
+	kind uploadKind,
+) (*upload, bool) {
+	file, header, err := r.FormFile(field)
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, true
+	} else if err != nil {
+		http.Error(w, "Failed to retrieve "+field+" file", http.StatusBadRequest)
+		return nil, false
+	}
+	file.Close()
+	return readUpload(w, ctx, header, kind)
+}
+
+// receiveUploads reads and validates all the files of a multipart form field, in the order they were sent; at most
+// limit of them. If any file is rejected, an error response is written and false returned.
+func receiveUploads(
+	w http.ResponseWriter,
+	r *http.Request,
+	ctx reqcontext.RequestContext,
+	field string,
+	kind uploadKind,
+	limit int,
+) ([]*upload, bool) {
+	if r.MultipartForm == nil {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	headers := r.MultipartForm.File[field]
+	if len(headers) > limit {
+		http.Error(w, fmt.Sprintf("At most %d files can be sent as %s.", limit, field), http.StatusBadRequest)
+		return nil, false
+	}
+	uploads := make([]*upload, 0, len(headers))
+	for _, header := range headers {
+		u, ok := readUpload(w, ctx, header, kind)
+		if !ok {
+			return nil, false
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, true
+}
+
+// readUpload reads and validates a file of a multipart form.
+func readUpload(
+	w http.ResponseWriter,
+	ctx reqcontext.RequestContext,
+	header *multipart.FileHeader,
+	kind uploadKind,
+) (*upload, bool) {
+	if header.Size > kind.maxSize {
+		writeUploadTooLarge(w, kind)
+		return nil, false
+	}
+	file, err := header.Open()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to open uploaded file")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, kind.maxSize+1))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to read uploaded file")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	u := checkUpload(w, ctx, data, kind)
+	if u == nil {
+		return nil, false
+	}
+	u.fileName = cleanFileName(header.Filename)
+	return u, true
+}
+
+// checkUpload validates the content of an upload against its kind: size, and type as sniffed from the bytes. Images
+// are sanitized. If the content is rejected, an error response is written and nil returned.
+func checkUpload(w http.ResponseWriter, ctx reqcontext.RequestContext, data []byte, kind uploadKind) *upload {
+	if int64(len(data)) > kind.maxSize {
+		writeUploadTooLarge(w, kind)
+		return nil
+	}
+	// Parameters such as the charset of text files are dropped; clients get them from the content.
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !kind.accepts(mimeType) {
+		http.Error(w, fmt.Sprintf("The %s cannot be of type %s.", kind.name, mimeType), http.StatusUnsupportedMediaType)
+		return nil
+	}
+	u := &upload{data: data, mimeType: mimeType}
+	if imageMimeTypes[mimeType] {
+		u.image = imageUpload(w, ctx, data)
+		if u.image == nil {
+			return nil
+		}
+		u.data, u.mimeType = u.image.Data, u.image.MimeType
+	}
+	return u
+}
+
+// cleanFileName keeps the base name of a client file name, without control characters and at most maxFileNameLength
+// bytes long.
+func cleanFileName(name string) string {
+	// Browsers on Windows may send the full path.
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	for len(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+func writeUploadTooLarge(w http.ResponseWriter, kind uploadKind) {
+	http.Error(w, fmt.Sprintf("The %s is too large. Maximum allowed size is %d MB.", kind.name, kind.maxSize>>20),
+		http.StatusRequestEntityTooLarge)
+}
+
+// imageUpload sanitizes an uploaded image, dropping its metadata. If the image is rejected, an error response is
+// written and nil returned.
+func imageUpload(w http.ResponseWriter, ctx reqcontext.RequestContext, data []byte) *imaging.Image {
+	img, err := imaging.Sanitize(data)
+	if errors.Is(err, imaging.ErrUnsupportedFormat) {
+		http.Error(w, "Unsupported image format. Only JPEG, PNG and GIF images are supported.", http.StatusUnsupportedMediaType)
+		return nil
+	} else if errors.Is(err, imaging.ErrTooLarge) {
+		http.Error(w, fmt.Sprintf("Image too large. Images may be at most %d pixels wide or high and %d megapixels in total.",
+			imaging.MaxSide, imaging.MaxPixels/1000000), http.StatusBadRequest)
+		return nil
+	} else if errors.Is(err, imaging.ErrInvalidImage) {
+		http.Error(w, "The image is corrupt and cannot be decoded.", http.StatusBadRequest)
+		return nil
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to re-encode image")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil
+	}
+	return img
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -12,8 +11,6 @@ import (
 	"github.com/nazerke1234/wasa/service/database"
 )
 
-var ErrUnauthorized = errors.New("unauthorized request")
-
 func (rt *_router) setMyUserName(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -24,11 +21,7 @@ func (rt *_router) setMyUserName(
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	userID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := ctx.UserID
 	var req UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -64,37 +57,21 @@ func (rt *_router) setMyPhoto(
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	userID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	err = r.ParseMultipartForm(10 * 1024 * 1024)
+	userID := ctx.UserID
+	err := r.ParseMultipartForm(10 * 1024 * 1024)
 	if err != nil {
 		http.Error(w, "Failed to parse form. Ensure the file is below 10 MB.", http.StatusBadRequest)
 		return
 	}
-	file, _, err := r.FormFile("photo")
-	if err != nil {
-		http.Error(w, "Failed to retrieve photo file", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-	photoData, err := io.ReadAll(file)
-	if err != nil {
-		http.Error(w, "Failed to read photo file", http.StatusInternalServerError)
-		return
-	}
-	if len(photoData) > 10*1024*1024 {
-		http.Error(w, "Photo too large. Maximum allowed size is 10 MB.", http.StatusRequestEntityTooLarge)
+	photo, ok := receiveUpload(w, r, ctx, "photo", photoUpload)
+	if !ok {
 		return
 	}
-	fileType := http.DetectContentType(photoData)
-	if fileType != "image/jpeg" && fileType != "image/png" {
-		http.Error(w, "Invalid file type. Only JPEG and PNG are supported.", http.StatusUnsupportedMediaType)
+	if photo == nil {
+		http.Error(w, "Failed to retrieve photo file", http.StatusBadRequest)
 		return
 	}
-	err = rt.db.UpdateUserPhoto(userID, photoData)
+	err = rt.db.UpdateUserPhoto(userID, photo.data, photoThumbnail(ctx, photo.image))
 	if errors.Is(err, database.ErrUserDoesNotExist) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
@@ -113,18 +90,6 @@ func (rt *_router) setMyPhoto(
 	}
 }
 
-func (rt *_router) getAuthenticatedUserID(r *http.Request) (string, error) {
-	authHeader := r.Header.Get("Authorization")
-	if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-		return "", ErrUnauthorized
-	}
-	userID := authHeader[7:]
-	if userID == "" {
-		return "", ErrUnauthorized
-	}
-	return userID, nil
-}
-
 func (rt *_router) searchUsers(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -165,11 +130,7 @@ func (rt *_router) getMyPhoto(
 	ps httprouter.Params,
 	ctx reqcontext.RequestContext,
 ) {
-	userID, err := rt.getAuthenticatedUserID(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := ctx.UserID
 	user, dbErr := rt.db.GetUsersPhoto(userID)
 	if errors.Is(dbErr, database.ErrUserDoesNotExist) {
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -192,3 +153,59 @@ func (rt *_router) getMyPhoto(
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+func (rt *_router) setMyPassword(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	ctx reqcontext.RequestContext,
+) {
+	var req UpdatePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.NewPassword) < minPasswordLength || len(req.NewPassword) > maxPasswordLength {
+		http.Error(w, "Invalid password length", http.StatusBadRequest)
+		return
+	}
+	currentHash, err := rt.db.GetUserPasswordHash(ctx.UserID)
+	if errors.Is(err, database.ErrUserDoesNotExist) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch password")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if currentHash != "" {
+		ok, err := checkPassword(req.CurrentPassword, currentHash)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Stored password hash is invalid")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Forbidden: current password is wrong", http.StatusForbidden)
+			return
+		}
+	}
+	newHash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to hash password")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.SetUserPasswordHash(ctx.UserID, newHash); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to store password")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Sessions opened before the change may belong to whoever knew the old credentials.
+	if err := rt.db.DeleteOtherSessions(ctx.UserID, ctx.SessionID); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to revoke other sessions")
+	} else {
+		rt.events.dropOtherSessions(ctx.UserID, ctx.SessionID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nazerke1234/wasa/service/api/reqcontext"
+	"github.com/sirupsen/logrus"
+)
+
+// setPasswordRequest changes the password of alice from the session she is logged in with.
+func setPasswordRequest(rt *_router, sessionID, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/users/me/password", strings.NewReader(body))
+	ctx := reqcontext.RequestContext{UserID: "alice", SessionID: sessionID, Logger: logrus.New()}
+	rt.setMyPassword(w, r, nil, ctx)
+	return w
+}
+
+func TestSetMyPassword(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		wantStatus      int
+		wantPassword    string
+		wantOtherActive bool
+	}{
+		{
+			name:            "wrong current password",
+			body:            `{"currentPassword": "wrong password", "newPassword": "new password"}`,
+			wantStatus:      http.StatusForbidden,
+			wantPassword:    "old password",
+			wantOtherActive: true,
+		},
+		{
+			name:            "too short",
+			body:            `{"currentPassword": "old password", "newPassword": "short"}`,
+			wantStatus:      http.StatusBadRequest,
+			wantPassword:    "old password",
+			wantOtherActive: true,
+		},
+		{
+			name:         "changed",
+			body:         `{"currentPassword": "old password", "newPassword": "new password"}`,
+			wantStatus:   http.StatusNoContent,
+			wantPassword: "new password",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, _ := newTestRouter(t)
+			rt.sessionTTL = time.Hour
+			hash, err := hashPassword("old password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rt.db.SetUserPasswordHash("alice", hash); err != nil {
+				t.Fatal(err)
+			}
+			_, current, err := rt.createSession("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, other, err := rt.createSession("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			otherStream, err := rt.events.subscribe("alice", other.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if w := setPasswordRequest(rt, current.Id, tt.body); w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			stored, err := rt.db.GetUserPasswordHash("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := checkPassword(tt.wantPassword, stored); err != nil || !ok {
+				t.Errorf("the password is not %q", tt.wantPassword)
+			}
+			sessions, err := rt.db.GetUserSessions("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			active := map[string]bool{}
+			for _, s := range sessions {
+				active[s.Id] = true
+			}
+			if !active[current.Id] {
+				t.Error("the session changing the password was revoked")
+			}
+			if active[other.Id] != tt.wantOtherActive {
+				t.Errorf("got other session active %v, want %v", active[other.Id], tt.wantOtherActive)
+			}
+			select {
+			case _, open := <-otherStream.events:
+				if open || tt.wantOtherActive {
+					t.Errorf("got the event stream of the other session open %v, want %v", open, tt.wantOtherActive)
+				}
+			default:
+				if !tt.wantOtherActive {
+					t.Error("the event stream of the other session is still open")
+				}
+			}
+		})
+	}
+}
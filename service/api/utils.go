@@ -8,6 +8,7 @@ import (
 )
 
 func (rt *_router) Close() error {
+	rt.events.close()
 	return nil
 }
 
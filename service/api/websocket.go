@@ -0,0 +1,292 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is the fixed key suffix of the opening handshake (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes.
+const (
+	websocketContinuation = 0x0
+	websocketText         = 0x1
+	websocketBinary       = 0x2
+	websocketClose        = 0x8
+	websocketPing         = 0x9
+	websocketPong         = 0xA
+)
+
+// WebSocket close status codes.
+const (
+	websocketCloseNormal        = 1000
+	websocketCloseGoingAway     = 1001
+	websocketCloseProtocolError = 1002
+	websocketCloseInvalidData   = 1007
+	websocketCloseTooBig        = 1009
+)
+
+// websocketMaxMessageSize is the largest message accepted from clients. Attachments are sent over REST.
+const websocketMaxMessageSize = 64 << 10
+
+// websocketWriteWait is how long a frame write may block before the connection is dropped.
+const websocketWriteWait = 10 * time.Second
+
+var (
+	errWebsocketProtocol    = errors.New("websocket protocol error")
+	errWebsocketTooBig      = errors.New("websocket message too big")
+	errWebsocketInvalidData = errors.New("websocket text message is not valid UTF-8")
+	errWebsocketOrigin      = errors.New("websocket origin not allowed")
+)
+
+// websocketConn is a server side WebSocket connection. Reads must happen from a single goroutine; writes are
+// serialized, so they can happen from any goroutine.
+type websocketConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+	bw      *bufio.Writer
+}
+
+// upgradeWebsocket performs the opening handshake and takes over the connection of the request. If the request is
+// not a valid WebSocket handshake, or comes from a page of an origin not allowed, an error response is written and an
+// error returned.
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request, allowedOrigins []string) (*websocketConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, errWebsocketProtocol
+	}
+	if !websocketOriginAllowed(r, allowedOrigins) {
+		http.Error(w, "Forbidden: origin not allowed", http.StatusForbidden)
+		return nil, errWebsocketOrigin
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Upgrade not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijacking connection: %w", err)
+	}
+	// The server timeouts were meant for the HTTP exchange, not for the connection that replaces it.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("clearing connection deadline: %w", err)
+	}
+
+	// SHA-1 is mandated by the handshake; it only proves the server understood the request.
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("writing handshake: %w", err)
+	}
+	return &websocketConn{conn: conn, br: rw.Reader, bw: rw.Writer}, nil
+}
+
+// websocketOriginAllowed reports whether the handshake may come from its Origin. Browsers send the origin of the page
+// opening the socket and let any page open one, so without this check any site could connect with a leaked token.
+// The API's own origin is always allowed, other ones must be listed. Handshakes without Origin do not come from
+// browsers and are allowed.
+func websocketOriginAllowed(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// headerContainsToken reports whether the comma separated header contains the token, ignoring case.
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next data message, answering pings and reassembling fragments on the way. After a close
+// frame from the peer, the close is echoed and io.EOF returned. Text messages that are not valid UTF-8 fail the
+// connection, as RFC 6455 section 8.1 requires.
+func (c *websocketConn) readMessage(idleTimeout time.Duration) (int, []byte, error) {
+	var opcode int
+	var message []byte
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+			return 0, nil, err
+		}
+		fin, frameOpcode, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, errWebsocketTooBig) {
+				_ = c.writeClose(websocketCloseTooBig)
+			} else if errors.Is(err, errWebsocketProtocol) {
+				_ = c.writeClose(websocketCloseProtocolError)
+			}
+			return 0, nil, err
+		}
+		switch frameOpcode {
+		case websocketPing:
+			if err := c.writeFrame(websocketPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case websocketPong:
+			continue
+		case websocketClose:
+			_ = c.writeClose(websocketCloseNormal)
+			return 0, nil, io.EOF
+		case websocketText, websocketBinary:
+			if message != nil {
+				_ = c.writeClose(websocketCloseProtocolError)
+				return 0, nil, errWebsocketProtocol
+			}
+			opcode = frameOpcode
+			message = payload
+		case websocketContinuation:
+			if message == nil {
+				_ = c.writeClose(websocketCloseProtocolError)
+				return 0, nil, errWebsocketProtocol
+			}
+			if len(message)+len(payload) > websocketMaxMessageSize {
+				_ = c.writeClose(websocketCloseTooBig)
+				return 0, nil, errWebsocketTooBig
+			}
+			message = append(message, payload...)
+		default:
+			_ = c.writeClose(websocketCloseProtocolError)
+			return 0, nil, errWebsocketProtocol
+		}
+		if fin {
+			if opcode == websocketText && !utf8.Valid(message) {
+				_ = c.writeClose(websocketCloseInvalidData)
+				return 0, nil, errWebsocketInvalidData
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks a single frame.
+func (c *websocketConn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0F)
+	if header[0]&0x70 != 0 {
+		// No extension was negotiated, so the reserved bits must be clear.
+		return false, 0, nil, errWebsocketProtocol
+	}
+	if header[1]&0x80 == 0 {
+		// Frames sent by clients must be masked.
+		return false, 0, nil, errWebsocketProtocol
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= websocketClose && (length > 125 || !fin) {
+		return false, 0, nil, errWebsocketProtocol
+	}
+	if length > websocketMaxMessageSize {
+		return false, 0, nil, errWebsocketTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a single unfragmented, unmasked frame.
+func (c *websocketConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait)); err != nil {
+		return err
+	}
+	header := []byte{0x80 | byte(opcode)}
+	switch length := len(payload); {
+	case length <= 125:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, byte(length>>8), byte(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if _, err := c.bw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// writeClose sends a close frame with the given status code.
+func (c *websocketConn) writeClose(code int) error {
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], uint16(code))
+	return c.writeFrame(websocketClose, payload[:])
+}
+
+// Close closes the underlying connection without a closing handshake.
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,494 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFrame is a frame as sent by a test client. Frames are masked unless unmasked is set, and rsv sets the reserved
+// bits.
+type testFrame struct {
+	fin      bool
+	opcode   int
+	payload  []byte
+	unmasked bool
+	rsv      byte
+}
+
+// encode returns the frame on the wire, with the shortest length encoding.
+func (f testFrame) encode() []byte {
+	var b bytes.Buffer
+	first := f.rsv<<4 | byte(f.opcode)
+	if f.fin {
+		first |= 0x80
+	}
+	b.WriteByte(first)
+	var maskBit byte = 0x80
+	if f.unmasked {
+		maskBit = 0
+	}
+	switch length := len(f.payload); {
+	case length <= 125:
+		b.WriteByte(maskBit | byte(length))
+	case length <= 0xFFFF:
+		b.WriteByte(maskBit | 126)
+		_ = binary.Write(&b, binary.BigEndian, uint16(length))
+	default:
+		b.WriteByte(maskBit | 127)
+		_ = binary.Write(&b, binary.BigEndian, uint64(length))
+	}
+	if f.unmasked {
+		b.Write(f.payload)
+		return b.Bytes()
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b.Write(mask[:])
+	for i, c := range f.payload {
+		b.WriteByte(c ^ mask[i%4])
+	}
+	return b.Bytes()
+}
+
+// serverFrame is a frame written by the server.
+type serverFrame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+// parseServerFrames splits what the server wrote into frames, checking they are unmasked as required.
+func parseServerFrames(t *testing.T, data []byte) []serverFrame {
+	t.Helper()
+	var frames []serverFrame
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			t.Fatalf("truncated frame header: %v", err)
+		}
+		if header[1]&0x80 != 0 {
+			t.Fatal("the server masked a frame")
+		}
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext uint16
+			_ = binary.Read(r, binary.BigEndian, &ext)
+			length = uint64(ext)
+		case 127:
+			_ = binary.Read(r, binary.BigEndian, &length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatalf("truncated frame payload: %v", err)
+		}
+		frames = append(frames, serverFrame{fin: header[0]&0x80 != 0, opcode: int(header[0] & 0x0F), payload: payload})
+	}
+	return frames
+}
+
+func closeFrame(code int) serverFrame {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return serverFrame{fin: true, opcode: websocketClose, payload: payload}
+}
+
+// runWebsocketConn sends the client frames to a server connection over a pipe, lets fn use the connection, and
+// returns what the server wrote back.
+func runWebsocketConn(t *testing.T, frames []testFrame, fn func(c *websocketConn)) []serverFrame {
+	t.Helper()
+	server, client := net.Pipe()
+	conn := &websocketConn{conn: server, br: bufio.NewReader(server), bw: bufio.NewWriter(server)}
+	go func() {
+		for _, f := range frames {
+			// Writes fail once the server gave up reading and closed its end.
+			if _, err := client.Write(f.encode()); err != nil {
+				return
+			}
+		}
+	}()
+	written := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(client)
+		written <- data
+	}()
+	fn(conn)
+	_ = conn.Close()
+	data := <-written
+	_ = client.Close()
+	return parseServerFrames(t, data)
+}
+
+func TestWebsocketReadMessage(t *testing.T) {
+	hello := []byte("hello")
+	tests := []struct {
+		name        string
+		frames      []testFrame
+		wantOpcode  int
+		wantMessage []byte
+		wantErr     error
+		wantWritten []serverFrame
+	}{
+		{
+			name:        "text with 7-bit length",
+			frames:      []testFrame{{fin: true, opcode: websocketText, payload: hello}},
+			wantOpcode:  websocketText,
+			wantMessage: hello,
+		},
+		{
+			name:        "binary with 16-bit length",
+			frames:      []testFrame{{fin: true, opcode: websocketBinary, payload: bytes.Repeat([]byte{7}, 300)}},
+			wantOpcode:  websocketBinary,
+			wantMessage: bytes.Repeat([]byte{7}, 300),
+		},
+		{
+			name:        "text with 64-bit length at the size limit",
+			frames:      []testFrame{{fin: true, opcode: websocketText, payload: bytes.Repeat([]byte("a"), websocketMaxMessageSize)}},
+			wantOpcode:  websocketText,
+			wantMessage: bytes.Repeat([]byte("a"), websocketMaxMessageSize),
+		},
+		{
+			name: "fragmented text",
+			frames: []testFrame{
+				{opcode: websocketText, payload: []byte("he")},
+				{opcode: websocketContinuation, payload: []byte("l")},
+				{fin: true, opcode: websocketContinuation, payload: []byte("lo")},
+			},
+			wantOpcode:  websocketText,
+			wantMessage: hello,
+		},
+		{
+			name: "ping between fragments",
+			frames: []testFrame{
+				{opcode: websocketText, payload: []byte("hel")},
+				{fin: true, opcode: websocketPing, payload: []byte("ping")},
+				{fin: true, opcode: websocketContinuation, payload: []byte("lo")},
+			},
+			wantOpcode:  websocketText,
+			wantMessage: hello,
+			wantWritten: []serverFrame{{fin: true, opcode: websocketPong, payload: []byte("ping")}},
+		},
+		{
+			name: "unsolicited pong",
+			frames: []testFrame{
+				{fin: true, opcode: websocketPong, payload: []byte("pong")},
+				{fin: true, opcode: websocketText, payload: hello},
+			},
+			wantOpcode:  websocketText,
+			wantMessage: hello,
+		},
+		{
+			name:        "close",
+			frames:      []testFrame{{fin: true, opcode: websocketClose, payload: []byte{0x03, 0xE8}}},
+			wantErr:     io.EOF,
+			wantWritten: []serverFrame{closeFrame(websocketCloseNormal)},
+		},
+		{
+			name:        "unmasked client frame",
+			frames:      []testFrame{{fin: true, opcode: websocketText, payload: hello, unmasked: true}},
+			wantErr:     errWebsocketProtocol,
+			wantWritten: []serverFrame{closeFrame(websocketCloseProtocolError)},
+		},
+		{
+			name:        "reserved bits set",
+			frames:      []testFrame{{fin: true, opcode: websocketText, payload: hello, rsv: 0x4}},
+			wantErr:     errWebsocketProtocol,
+			wantWritten: []serverFrame{closeFrame(websocketCloseProtocolError)},
+		},
+		{
+			name:        "unknown opcode",
+			frames:      []testFrame{{fin: true, opcode: 0x3, payload: hello}},
+			wantErr:     errWebsocketProtocol,
+			wantWritten: []serverFrame{closeFrame(websocketCloseProtocolError)},
+		},
+		{
+			name:        "continuation without a message",
+			frames:      []testFrame{{fin: true, opcode: websocketContinuation, payload: hello}},
+			wantErr:     errWebsocketProtocol,
+			wantWritten: []serverFrame{closeFrame(websocketCloseProtocolError)},
+		},
+		{
+			name: "new message within a fragmented one",
+			frames: []testFrame{
+				{opcode: websocketText, payload: []byte("hel")},
+				{fin: true, opcode: websocketText, payload: []byte("lo")},
+			},
+			wantErr:     errWebsocketProtocol,
+			wantWritten: []serverFrame{closeFrame(websocketCloseProtocolError)},
+		},
+		{
+			name:        "fragmented control frame",
+			frames:      []testFrame{{opcode: websocketPing, payload: []byte("ping")}},
+			wantErr:     errWebsocketProtocol,
+			wantWritten: []serverFrame{closeFrame(websocketCloseProtocolError)},
+		},
+		{
+			name:        "control frame over 125 bytes",
+			frames:      []testFrame{{fin: true, opcode: websocketPing, payload: bytes.Repeat([]byte("p"), 126)}},
+			wantErr:     errWebsocketProtocol,
+			wantWritten: []serverFrame{closeFrame(websocketCloseProtocolError)},
+		},
+		{
+			name:        "oversize frame",
+			frames:      []testFrame{{fin: true, opcode: websocketText, payload: make([]byte, websocketMaxMessageSize+1)}},
+			wantErr:     errWebsocketTooBig,
+			wantWritten: []serverFrame{closeFrame(websocketCloseTooBig)},
+		},
+		{
+			name:        "text not valid UTF-8",
+			frames:      []testFrame{{fin: true, opcode: websocketText, payload: []byte{'h', 0xFF, 'i'}}},
+			wantErr:     errWebsocketInvalidData,
+			wantWritten: []serverFrame{closeFrame(websocketCloseInvalidData)},
+		},
+		{
+			name: "text truncated within a character",
+			frames: []testFrame{
+				{opcode: websocketText, payload: []byte("caf")},
+				{fin: true, opcode: websocketContinuation, payload: []byte{0xC3}},
+			},
+			wantErr:     errWebsocketInvalidData,
+			wantWritten: []serverFrame{closeFrame(websocketCloseInvalidData)},
+		},
+		{
+			name: "character split across fragments",
+			frames: []testFrame{
+				{opcode: websocketText, payload: []byte{'c', 'a', 'f', 0xC3}},
+				{fin: true, opcode: websocketContinuation, payload: []byte{0xA9}},
+			},
+			wantOpcode:  websocketText,
+			wantMessage: []byte("café"),
+		},
+		{
+			name:        "binary not valid UTF-8",
+			frames:      []testFrame{{fin: true, opcode: websocketBinary, payload: []byte{0xFF, 0xFE}}},
+			wantOpcode:  websocketBinary,
+			wantMessage: []byte{0xFF, 0xFE},
+		},
+		{
+			name: "oversize fragmented message",
+			frames: []testFrame{
+				{opcode: websocketText, payload: make([]byte, websocketMaxMessageSize/2+1)},
+				{fin: true, opcode: websocketContinuation, payload: make([]byte, websocketMaxMessageSize/2)},
+			},
+			wantErr:     errWebsocketTooBig,
+			wantWritten: []serverFrame{closeFrame(websocketCloseTooBig)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opcode int
+			var message []byte
+			var err error
+			written := runWebsocketConn(t, tt.frames, func(c *websocketConn) {
+				opcode, message, err = c.readMessage(time.Second)
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if opcode != tt.wantOpcode || !bytes.Equal(message, tt.wantMessage) {
+				t.Errorf("got message %d %.20q, want %d %.20q", opcode, message, tt.wantOpcode, tt.wantMessage)
+			}
+			if len(written) != len(tt.wantWritten) {
+				t.Fatalf("the server wrote %d frames, want %d", len(written), len(tt.wantWritten))
+			}
+			for i, f := range written {
+				want := tt.wantWritten[i]
+				if f.fin != want.fin || f.opcode != want.opcode || !bytes.Equal(f.payload, want.payload) {
+					t.Errorf("frame %d: got %+v, want %+v", i, f, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWebsocketReadMessageIdleTimeout(t *testing.T) {
+	runWebsocketConn(t, nil, func(c *websocketConn) {
+		_, _, err := c.readMessage(10 * time.Millisecond)
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("got error %v, want a timeout", err)
+		}
+	})
+}
+
+func TestWebsocketWriteFrame(t *testing.T) {
+	tests := []struct {
+		name       string
+		length     int
+		wantHeader []byte
+	}{
+		{"empty", 0, []byte{0x81, 0}},
+		{"7-bit length", 125, []byte{0x81, 125}},
+		{"16-bit length", 126, []byte{0x81, 126, 0, 126}},
+		{"16-bit length at its limit", 0xFFFF, []byte{0x81, 126, 0xFF, 0xFF}},
+		{"64-bit length", 0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := bytes.Repeat([]byte("x"), tt.length)
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := &websocketConn{conn: server, br: bufio.NewReader(server), bw: bufio.NewWriter(server)}
+			go func() {
+				if err := conn.writeFrame(websocketText, payload); err != nil {
+					t.Error(err)
+				}
+				_ = conn.Close()
+			}()
+			data, _ := io.ReadAll(client)
+			if !bytes.HasPrefix(data, tt.wantHeader) {
+				t.Fatalf("got header % x, want % x", data[:len(tt.wantHeader)], tt.wantHeader)
+			}
+			if !bytes.Equal(data[len(tt.wantHeader):], payload) {
+				t.Error("the payload does not follow the header as is")
+			}
+		})
+	}
+}
+
+func TestUpgradeWebsocketRejectsInvalidHandshake(t *testing.T) {
+	valid := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/socket", nil)
+		r.Header.Set("Connection", "keep-alive, Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		return r
+	}
+	tests := []struct {
+		name   string
+		change func(r *http.Request)
+	}{
+		{"POST", func(r *http.Request) { r.Method = http.MethodPost }},
+		{"no connection upgrade", func(r *http.Request) { r.Header.Set("Connection", "keep-alive") }},
+		{"no upgrade to websocket", func(r *http.Request) { r.Header.Set("Upgrade", "h2c") }},
+		{"other version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }},
+		{"no key", func(r *http.Request) { r.Header.Del("Sec-WebSocket-Key") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.change(r)
+			w := httptest.NewRecorder()
+			if _, err := upgradeWebsocket(w, r, nil); !errors.Is(err, errWebsocketProtocol) {
+				t.Errorf("got error %v, want %v", err, errWebsocketProtocol)
+			}
+			if w.Code != http.StatusBadRequest || w.Header().Get("Sec-WebSocket-Version") != "13" {
+				t.Errorf("got status %d and version %q", w.Code, w.Header().Get("Sec-WebSocket-Version"))
+			}
+		})
+	}
+}
+
+func TestWebsocketOriginAllowed(t *testing.T) {
+	allowed := []string{"http://localhost:5173"}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://api.example.com", true},
+		{"https://API.example.com", true},
+		{"http://localhost:5173", true},
+		{"http://evil.example.com", false},
+		{"http://api.example.com.evil.example.com", false},
+		{"http://localhost:5174", false},
+		{"null", false},
+		{"%", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example.com/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := websocketOriginAllowed(r, allowed); got != tt.want {
+			t.Errorf("origin %q: got %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestUpgradeWebsocketRejectsOtherOrigin(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/ws", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Origin", "http://evil.example.com")
+	w := httptest.NewRecorder()
+	if _, err := upgradeWebsocket(w, r, nil); !errors.Is(err, errWebsocketOrigin) {
+		t.Errorf("got error %v, want %v", err, errWebsocketOrigin)
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestUpgradeWebsocket(t *testing.T) {
+	// The server echoes one message over the upgraded connection.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebsocket(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		opcode, message, err := conn.readMessage(time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := conn.writeFrame(opcode, message); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The key and accept value are the example of RFC 6455, section 1.3.
+	_, err = io.WriteString(conn, "GET /socket HTTP/1.1\r\n"+
+		"Host: "+strings.TrimPrefix(server.URL, "http://")+"\r\n"+
+		"Origin: "+server.URL+"\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got accept value %q", got)
+	}
+	if _, err := conn.Write(testFrame{fin: true, opcode: websocketText, payload: []byte("hello")}.encode()); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	echo, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := parseServerFrames(t, echo)
+	if len(frames) != 1 || frames[0].opcode != websocketText || string(frames[0].payload) != "hello" {
+		t.Errorf("got frames %+v, want the echoed message", frames)
+	}
+}
@@ -1,23 +1,126 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 )
 
-func (db *appdbimpl) CommentMessage(commentID, messageID, authorID string) error {
-	query := `INSERT INTO comments (id, messageId, authorId) VALUES (?, ?, ?)`
-	_, err := db.c.Exec(query, commentID, messageID, authorID)
+// AddComment adds a comment of the user to the thread of a message of the conversation and returns it.
+func (db *appdbimpl) AddComment(conversationID, messageID, commentID, authorID, content string) (Comment, error) {
+	if err := db.checkMessageLive(conversationID, messageID); err != nil {
+		return Comment{}, err
+	}
+	_, err := db.c.Exec(`
+		INSERT INTO comments (id, messageId, authorId, content, createdAt)
+		VALUES (?, ?, ?, ?, ?)
+	`, commentID, messageID, authorID, content, time.Now().UTC().Format(MessageTimestampFormat))
 	if err != nil {
-		return fmt.Errorf("failed to insert comment: %w", err)
+		return Comment{}, fmt.Errorf("error saving comment: %w", err)
 	}
-	return nil
+	return db.getComment(conversationID, messageID, commentID)
 }
 
-func (db *appdbimpl) UncommentMessage(messageID, authorID string) error {
-	query := `DELETE FROM comments WHERE messageId = ? AND authorId = ?`
-	_, err := db.c.Exec(query, messageID, authorID)
+// EditComment replaces the content of a comment of the user and returns the edited comment. Editing to the same
+// content changes nothing.
+func (db *appdbimpl) EditComment(conversationID, messageID, commentID, userID, content string) (Comment, error) {
+	comment, err := db.getComment(conversationID, messageID, commentID)
 	if err != nil {
-		return fmt.Errorf("failed to delete comment: %w", err)
+		return Comment{}, err
+	}
+	if comment.AuthorId != userID {
+		return Comment{}, ErrUnauthorizedToChangeComment
+	}
+	if content == comment.Content {
+		return comment, nil
+	}
+	_, err = db.c.Exec(`UPDATE comments SET content = ?, editedAt = ? WHERE id = ?`,
+		content, time.Now().UTC().Format(MessageTimestampFormat), commentID)
+	if err != nil {
+		return Comment{}, fmt.Errorf("error editing comment: %w", err)
+	}
+	return db.getComment(conversationID, messageID, commentID)
+}
+
+// DeleteComment removes a comment of the user from the thread of a message.
+func (db *appdbimpl) DeleteComment(conversationID, messageID, commentID, userID string) error {
+	comment, err := db.getComment(conversationID, messageID, commentID)
+	if err != nil {
+		return err
+	}
+	if comment.AuthorId != userID {
+		return ErrUnauthorizedToChangeComment
+	}
+	if _, err := db.c.Exec(`DELETE FROM comments WHERE id = ?`, commentID); err != nil {
+		return fmt.Errorf("error deleting comment: %w", err)
 	}
 	return nil
 }
+
+// GetComments returns a page of the thread of a message of the conversation in chronological order: the oldest
+// comments, or those right after a cursor.
+func (db *appdbimpl) GetComments(conversationID, messageID string, query CommentQuery) (CommentPage, error) {
+	var exists bool
+	err := db.c.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM messages WHERE conversationId = ? AND id = ?)
+	`, conversationID, messageID).Scan(&exists)
+	if err != nil {
+		return CommentPage{}, fmt.Errorf("error checking message existence: %w", err)
+	}
+	if !exists {
+		return CommentPage{}, ErrMessageDoesNotExist
+	}
+	after := CommentCursor{}
+	if query.After != nil {
+		after = *query.After
+	}
+	// One more comment than asked for tells whether the thread goes on.
+	rows, err := db.c.Query(`
+		SELECT c.id, c.messageId, c.authorId, u.name, c.content, c.createdAt, IFNULL(c.editedAt, '')
+		FROM comments c
+		JOIN users u ON u.id = c.authorId
+		WHERE c.messageId = ? AND (c.createdAt, c.id) > (?, ?)
+		ORDER BY c.createdAt ASC, c.id ASC
+		LIMIT ?
+	`, messageID, after.CreatedAt, after.Id, query.Limit+1)
+	if err != nil {
+		return CommentPage{}, fmt.Errorf("error fetching comments: %w", err)
+	}
+	defer rows.Close()
+	page := CommentPage{Comments: []Comment{}}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.Id, &c.MessageId, &c.AuthorId, &c.AuthorName, &c.Content, &c.CreatedAt, &c.EditedAt); err != nil {
+			return CommentPage{}, fmt.Errorf("error scanning comment: %w", err)
+		}
+		page.Comments = append(page.Comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return CommentPage{}, fmt.Errorf("error iterating comments: %w", err)
+	}
+	if len(page.Comments) > query.Limit {
+		page.Comments = page.Comments[:query.Limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// getComment returns a comment of the thread of a message of the conversation.
+func (db *appdbimpl) getComment(conversationID, messageID, commentID string) (Comment, error) {
+	var c Comment
+	err := db.c.QueryRow(`
+		SELECT c.id, c.messageId, c.authorId, u.name, c.content, c.createdAt, IFNULL(c.editedAt, '')
+		FROM comments c
+		JOIN users u ON u.id = c.authorId
+		JOIN messages m ON m.id = c.messageId
+		WHERE c.id = ? AND c.messageId = ? AND m.conversationId = ?
+	`, commentID, messageID, conversationID).Scan(&c.Id, &c.MessageId, &c.AuthorId, &c.AuthorName, &c.Content,
+		&c.CreatedAt, &c.EditedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Comment{}, ErrCommentDoesNotExist
+	} else if err != nil {
+		return Comment{}, fmt.Errorf("error fetching comment: %w", err)
+	}
+	return c, nil
+}
@@ -30,27 +30,31 @@ func (db *appdbimpl) GetDirectConversation(senderID, recipientID string) (string
 	return conversationID, nil
 }
 
+// CreateDirectConversation creates the conversation between two users, with both as members, or nothing on failure.
 func (db *appdbimpl) CreateDirectConversation(conversationID, senderID, recipientID string) error {
-	_, err := db.c.Exec(`
-		INSERT INTO conversations (id, name, type, created_at, conversationPhoto)
-		VALUES (?, '', 'direct', ?, '')
-	`, conversationID, time.Now().Format(time.RFC3339))
-	if err != nil {
-		return fmt.Errorf("error creating new conversation: %w", err)
-	}
-	_, err = db.c.Exec(`
-		INSERT INTO conversation_members (conversationId, userId)
-		VALUES (?, ?), (?, ?)
-	`, conversationID, senderID,
-		conversationID, recipientID)
-	if err != nil {
-		return fmt.Errorf("error adding members to conversation_members: %w", err)
-	}
-	return nil
+	createdAt := time.Now().UTC().Format(MessageTimestampFormat)
+	return db.withTx(func(tx *appdbimpl) error {
+		_, err := tx.c.Exec(`
+			INSERT INTO conversations (id, name, type, created_at, conversationPhoto)
+			VALUES (?, '', 'direct', ?, '')
+		`, conversationID, createdAt)
+		if err != nil {
+			return fmt.Errorf("error creating new conversation: %w", err)
+		}
+		_, err = tx.c.Exec(`
+			INSERT INTO conversation_members (conversationId, userId, joinedAt)
+			VALUES (?, ?, ?), (?, ?, ?)
+		`, conversationID, senderID, createdAt,
+			conversationID, recipientID, createdAt)
+		if err != nil {
+			return fmt.Errorf("error adding members to conversation_members: %w", err)
+		}
+		return nil
+	})
 }
 
 func (db *appdbimpl) SaveMessage(
-	conversationID, senderID, messageID, content string, attachment []byte, replyTo string,
+	conversationID, senderID, messageID, content string, attachments []Attachment, replyTo string,
 ) (Message, error) {
 	var conversationExists bool
 	err := db.c.QueryRow(`SELECT EXISTS(SELECT 1 FROM conversations WHERE id = ?)`, conversationID).Scan(&conversationExists)
@@ -60,25 +64,71 @@ func (db *appdbimpl) SaveMessage(
 	if !conversationExists {
 		return Message{}, ErrConversationDoesNotExist
 	}
-	timestamp := time.Now().Format(time.RFC3339)
-	_, err = db.c.Exec(`
-        INSERT INTO messages (id, conversationId, senderId, content, timestamp, attachment, replyTo)
-        VALUES (?, ?, ?, ?, ?, ?, ?)
-    `, messageID, conversationID, senderID, content, timestamp, attachment, replyTo)
+	// Replies only quote messages of the same conversation, whose members may read them.
+	if replyTo != "" {
+		var replyExists bool
+		err := db.c.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM messages WHERE id = ? AND conversationId = ?)
+		`, replyTo, conversationID).Scan(&replyExists)
+		if err != nil {
+			return Message{}, fmt.Errorf("error checking replied message: %w", err)
+		}
+		if !replyExists {
+			return Message{}, ErrReplyNotInConversation
+		}
+	}
+	timestamp := time.Now().UTC().Format(MessageTimestampFormat)
+	err = db.withTx(func(tx *appdbimpl) error {
+		_, err := tx.c.Exec(`
+			INSERT INTO messages (id, conversationId, senderId, content, timestamp, replyTo)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, messageID, conversationID, senderID, content, timestamp, replyTo)
+		if err != nil {
+			return fmt.Errorf("error saving message: %w", err)
+		}
+		return tx.saveAttachments(messageID, attachments)
+	})
 	if err != nil {
-		return Message{}, fmt.Errorf("error saving message: %w", err)
+		return Message{}, err
 	}
 	return Message{
 		Id:             messageID,
 		ConversationId: conversationID,
+		Kind:           MessageKindUser,
 		SenderId:       senderID,
 		Content:        content,
 		Timestamp:      timestamp,
-		Attachment:     attachment,
+		Attachments:    attachments,
+		Reactions:      []Reaction{},
+		Status:         MessageStatusSent,
 		ReplyTo:        replyTo,
 	}, nil
 }
 
+func (db *appdbimpl) SaveSystemMessage(conversationID, messageID, content string, event SystemEvent) (Message, error) {
+	timestamp := time.Now().UTC().Format(MessageTimestampFormat)
+	_, err := db.c.Exec(`
+        INSERT INTO messages (id, conversationId, senderId, content, timestamp, replyTo, kind, eventType, eventTargetId, eventValue)
+        VALUES (?, ?, ?, ?, ?, '', ?, ?, ?, ?)
+    `, messageID, conversationID, event.ActorId, content, timestamp, MessageKindSystem,
+		event.Type, event.TargetId, event.Value)
+	if err != nil {
+		return Message{}, fmt.Errorf("error saving system message: %w", err)
+	}
+	return Message{
+		Id:             messageID,
+		ConversationId: conversationID,
+		Kind:           MessageKindSystem,
+		SenderId:       event.ActorId,
+		SenderName:     event.ActorName,
+		Content:        content,
+		Timestamp:      timestamp,
+		Reactions:      []Reaction{},
+		Status:         MessageStatusSent,
+		Event:          &event,
+	}, nil
+}
+
 func (db *appdbimpl) GetConversationMembers(conversationID string) ([]string, error) {
 	rows, err := db.c.Query(`
 		SELECT userId
@@ -103,17 +153,6 @@ func (db *appdbimpl) GetConversationMembers(conversationID string) ([]string, er
 	return members, nil
 }
 
-func (db *appdbimpl) InsertDeliveryReceipt(messageID, userID, deliveredAt string) error {
-	_, err := db.c.Exec(`
-		INSERT INTO read_receipts (messageId, userId, deliveredAt)
-		VALUES (?, ?, ?)
-	`, messageID, userID, deliveredAt)
-	if err != nil {
-		return fmt.Errorf("error inserting delivery receipt: %w", err)
-	}
-	return nil
-}
-
 func (db *appdbimpl) IsUserInConversation(conversationID, userID string) (bool, error) {
 	var exists bool
 	err := db.c.QueryRow(`
@@ -133,7 +172,7 @@ func (db *appdbimpl) GetConversationDetails(conversationID, currentUserID string
 	var conversation Conversation
 	var photoData []byte
 	err := db.c.QueryRow(`
-		SELECT id, name, type, created_at, conversationPhoto
+		SELECT id, name, type, created_at, COALESCE(conversationPhotoThumb, conversationPhoto)
 		FROM conversations
 		WHERE id = ?
 	`, conversationID).Scan(
@@ -172,7 +211,7 @@ func (db *appdbimpl) GetConversationDetails(conversationID, currentUserID string
 		}
 		if otherUserID != "" {
 			var userPhotoData []byte
-			err := db.c.QueryRow("SELECT photo FROM users WHERE id = ?", otherUserID).Scan(&userPhotoData)
+			err := db.c.QueryRow("SELECT COALESCE(photoThumb, photo) FROM users WHERE id = ?", otherUserID).Scan(&userPhotoData)
 			if err == nil && len(userPhotoData) > 0 {
 				conversation.ConversationPhoto = sql.NullString{
 					String: base64.StdEncoding.EncodeToString(userPhotoData),
@@ -181,94 +220,280 @@ func (db *appdbimpl) GetConversationDetails(conversationID, currentUserID string
 			}
 		}
 	}
-	messages, err := db.GetMessagesForConversation(conversationID)
+	return conversation, nil
+}
+
+// GetConversationPhoto returns the full size photo of a conversation: the group photo, or the photo of the other
+// member of a direct conversation.
+func (db *appdbimpl) GetConversationPhoto(conversationID, currentUserID string) ([]byte, error) {
+	var photo []byte
+	err := db.c.QueryRow(`
+		SELECT CASE
+			WHEN c.type = 'direct' THEN
+				(SELECT u.photo
+				FROM users u
+				JOIN conversation_members cm ON u.id = cm.userId
+				WHERE cm.conversationId = c.id AND u.id != ?)
+			ELSE c.conversationPhoto
+		END
+		FROM conversations c
+		WHERE c.id = ?
+	`, currentUserID, conversationID).Scan(&photo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationDoesNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("error fetching conversation photo: %w", err)
+	}
+	if len(photo) == 0 {
+		return nil, ErrPhotoDoesNotExist
+	}
+	return photo, nil
+}
+
+// notHidden is the condition on messages that leaves out those hidden by a user, given as parameter.
+const notHidden = `id NOT IN (SELECT messageId FROM hidden_messages WHERE userId = ?)`
+
+// GetMessages returns a page of the conversation's messages in chronological order: the newest ones, or those right
+// before or after a cursor.
+func (db *appdbimpl) GetMessages(conversationID string, query MessageQuery) (MessagePage, error) {
+	var keys *sql.Rows
+	var err error
+	switch {
+	case query.Before != nil:
+		keys, err = db.c.Query(`
+			SELECT id FROM messages
+			WHERE conversationId = ? AND (timestamp, id) < (?, ?) AND `+notHidden+`
+			ORDER BY timestamp DESC, id DESC
+			LIMIT ?
+		`, conversationID, query.Before.Timestamp, query.Before.Id, query.UserID, query.Limit)
+	case query.After != nil:
+		keys, err = db.c.Query(`
+			SELECT id FROM messages
+			WHERE conversationId = ? AND (timestamp, id) > (?, ?) AND `+notHidden+`
+			ORDER BY timestamp ASC, id ASC
+			LIMIT ?
+		`, conversationID, query.After.Timestamp, query.After.Id, query.UserID, query.Limit)
+	default:
+		keys, err = db.c.Query(`
+			SELECT id FROM messages
+			WHERE conversationId = ? AND `+notHidden+`
+			ORDER BY timestamp DESC, id DESC
+			LIMIT ?
+		`, conversationID, query.UserID, query.Limit)
+	}
 	if err != nil {
-		return Conversation{}, fmt.Errorf("error fetching conversation messages: %w", err)
+		return MessagePage{}, fmt.Errorf("error fetching message page: %w", err)
 	}
-	conversation.Messages = messages
-	return conversation, nil
+	ids, err := scanMessageIDs(keys)
+	if err != nil {
+		return MessagePage{}, err
+	}
+	return db.messagePage(conversationID, query.UserID, ids)
+}
+
+// GetMessagesAround returns the message and up to limit messages around it, half older and half newer, in
+// chronological order. Messages hidden by the user are left out; ErrMessageDoesNotExist is returned if the message
+// itself is.
+func (db *appdbimpl) GetMessagesAround(conversationID, messageID, userID string, limit int) (MessagePage, error) {
+	var timestamp string
+	err := db.c.QueryRow(`SELECT timestamp FROM messages WHERE id = ? AND conversationId = ? AND `+notHidden,
+		messageID, conversationID, userID).Scan(&timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MessagePage{}, ErrMessageDoesNotExist
+	} else if err != nil {
+		return MessagePage{}, fmt.Errorf("error fetching message: %w", err)
+	}
+	older := limit / 2
+	keys, err := db.c.Query(`
+		SELECT id FROM (
+			SELECT id FROM messages
+			WHERE conversationId = ? AND (timestamp, id) < (?, ?) AND `+notHidden+`
+			ORDER BY timestamp DESC, id DESC
+			LIMIT ?
+		)
+		UNION ALL
+		SELECT id FROM (
+			SELECT id FROM messages
+			WHERE conversationId = ? AND (timestamp, id) >= (?, ?) AND `+notHidden+`
+			ORDER BY timestamp ASC, id ASC
+			LIMIT ?
+		)
+	`, conversationID, timestamp, messageID, userID, older, conversationID, timestamp, messageID, userID, limit-older+1)
+	if err != nil {
+		return MessagePage{}, fmt.Errorf("error fetching messages around message: %w", err)
+	}
+	ids, err := scanMessageIDs(keys)
+	if err != nil {
+		return MessagePage{}, err
+	}
+	return db.messagePage(conversationID, userID, ids)
+}
+
+func scanMessageIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning message id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message ids: %w", err)
+	}
+	return ids, nil
+}
+
+// messagePage loads the messages with the given IDs and tells whether the conversation has messages beyond them.
+func (db *appdbimpl) messagePage(conversationID, userID string, ids []string) (MessagePage, error) {
+	messages, err := db.loadMessages(ids)
+	if err != nil {
+		return MessagePage{}, err
+	}
+	page := MessagePage{Messages: messages}
+	if len(messages) == 0 {
+		return page, nil
+	}
+	first, last := messages[0], messages[len(messages)-1]
+	err = db.c.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM messages WHERE conversationId = ? AND (timestamp, id) < (?, ?) AND `+notHidden+`),
+			EXISTS(SELECT 1 FROM messages WHERE conversationId = ? AND (timestamp, id) > (?, ?) AND `+notHidden+`)
+	`, conversationID, first.Timestamp, first.Id, userID,
+		conversationID, last.Timestamp, last.Id, userID).Scan(&page.HasOlder, &page.HasNewer)
+	if err != nil {
+		return MessagePage{}, fmt.Errorf("error checking for more messages: %w", err)
+	}
+	return page, nil
 }
 
-func (db *appdbimpl) GetMessagesForConversation(conversationID string) ([]Message, error) {
+// loadMessages returns the messages with the given IDs, with their sender, reactions, comment count, reply preview and
+// read status, in chronological order.
+func (db *appdbimpl) loadMessages(ids []string) ([]Message, error) {
+	if len(ids) == 0 {
+		return []Message{}, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
 	query := `
 SELECT 
     m.id, 
     m.conversationId, 
+    m.kind,
     m.senderId, 
     m.content, 
     m.timestamp, 
-    m.attachment,
+    IFNULL(m.editedAt, ''),
+    IFNULL(m.deletedAt, ''),
     m.replyTo,
     u.name AS senderName,
-    u.photo AS senderPhoto,
-    ((SELECT COUNT(*) FROM conversation_members WHERE conversationId = m.conversationId) - 1) AS totalRecipients,
+    COALESCE(u.photoThumb, u.photo) AS senderPhoto,
+    (SELECT COUNT(*) FROM read_receipts WHERE messageId = m.id) AS recipients,
+    (SELECT COUNT(*) FROM read_receipts WHERE messageId = m.id AND deliveredAt IS NOT NULL) AS deliveredCount,
     (SELECT COUNT(*) FROM read_receipts WHERE messageId = m.id AND readAt IS NOT NULL) AS readCount,
-    COUNT(c.id) AS reaction_count,
-    GROUP_CONCAT(DISTINCT u2.name) AS reacting_user_names,
+    (SELECT COUNT(*) FROM comments WHERE messageId = m.id) AS commentCount,
     IFNULL(r.content, '') AS replyContent,
     IFNULL(ru.name, '') AS replySenderName,
-    r.attachment AS replyAttachment
+    r.deletedAt IS NOT NULL AS replyDeleted,
+    r.id IS NOT NULL AS replyFound,
+    m.eventType,
+    IFNULL(m.eventTargetId, ''),
+    IFNULL(m.eventValue, ''),
+    IFNULL(tu.name, '') AS eventTargetName
 FROM messages m
 JOIN users u ON m.senderId = u.id
-LEFT JOIN comments c ON m.id = c.messageId
-LEFT JOIN users u2 ON c.authorId = u2.id
-LEFT JOIN messages r ON m.replyTo = r.id
+LEFT JOIN users tu ON m.eventTargetId = tu.id
+LEFT JOIN messages r ON m.replyTo = r.id AND r.conversationId = m.conversationId
 LEFT JOIN users ru ON r.senderId = ru.id
-WHERE m.conversationId = ?
-GROUP BY m.id
-ORDER BY m.timestamp ASC;
+WHERE m.id IN (` + placeholders + `)
+ORDER BY m.timestamp ASC, m.id ASC;
 `
-	rows, err := db.c.Query(query, conversationID)
+	rows, err := db.c.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching messages: %w", err)
 	}
 	defer rows.Close()
-	var messages []Message
+	messages := make([]Message, 0, len(ids))
+	// replyFound tells, for each message, whether it replies to a message of its conversation.
+	replyFound := make([]bool, 0, len(ids))
 	for rows.Next() {
 		var msg Message
+		var found bool
 		var senderPhoto []byte
-		var totalRecipients, readCount, reactionCount int
-		var reactingUserNames sql.NullString
-		err := rows.Scan(
-			&msg.Id,
-			&msg.ConversationId,
-			&msg.SenderId,
-			&msg.Content,
-			&msg.Timestamp,
-			&msg.Attachment,
+		var recipients, deliveredCount, readCount int
+		var eventType sql.NullString
+		var event SystemEvent
+		dest := []interface{}{&msg.Id, &msg.ConversationId, &msg.Kind, &msg.SenderId, &msg.Content, &msg.Timestamp,
+			&msg.EditedAt,
+			&msg.DeletedAt,
 			&msg.ReplyTo,
 			&msg.SenderName,
 			&senderPhoto,
-			&totalRecipients,
+			&recipients,
+			&deliveredCount,
 			&readCount,
-			&reactionCount,
-			&reactingUserNames,
+			&msg.CommentCount,
 			&msg.ReplyContent,
 			&msg.ReplySenderName,
-			&msg.ReplyAttachment,
-		)
-		if err != nil {
+			&msg.ReplyDeleted,
+			&found,
+			&eventType, &event.TargetId, &event.Value, &event.TargetName,
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, fmt.Errorf("error scanning message row: %w", err)
 		}
+		if msg.Kind == MessageKindSystem && eventType.Valid {
+			event.Type = eventType.String
+			event.ActorId = msg.SenderId
+			event.ActorName = msg.SenderName
+			msg.Event = &event
+		}
 		if senderPhoto != nil {
 			msg.SenderPhoto = base64.StdEncoding.EncodeToString(senderPhoto)
 		}
-		msg.ReactionCount = reactionCount
-		if reactingUserNames.Valid && reactingUserNames.String != "" {
-			msg.ReactingUserNames = strings.Split(reactingUserNames.String, ",")
-		} else {
-			msg.ReactingUserNames = []string{}
-		}
-		if totalRecipients > 0 && readCount >= totalRecipients {
-			msg.Status = "✓✓"
-		} else {
-			msg.Status = "✓"
+		switch {
+		case recipients > 0 && readCount == recipients:
+			msg.Status = MessageStatusRead
+		case recipients > 0 && deliveredCount == recipients:
+			msg.Status = MessageStatusDelivered
+		default:
+			msg.Status = MessageStatusSent
 		}
 		messages = append(messages, msg)
+		replyFound = append(replyFound, found)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating message rows: %w", err)
 	}
+	attachmentIDs := make([]string, 0, 2*len(messages))
+	for i, msg := range messages {
+		attachmentIDs = append(attachmentIDs, msg.Id)
+		if replyFound[i] {
+			attachmentIDs = append(attachmentIDs, msg.ReplyTo)
+		}
+	}
+	attachments, err := db.loadAttachments(attachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+	reactions, err := db.loadReactions(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].Id]
+		messages[i].Reactions = reactions[messages[i].Id]
+		if messages[i].Reactions == nil {
+			messages[i].Reactions = []Reaction{}
+		}
+		if replyFound[i] {
+			messages[i].ReplyAttachments = attachments[messages[i].ReplyTo]
+		}
+	}
 	return messages, nil
 }
 
@@ -289,29 +514,35 @@ func (db *appdbimpl) GetMyConversations(userID string) ([]Conversation, error) {
 		c.created_at,
 		CASE 
 			WHEN c.type = 'direct' THEN 
-				(SELECT u.photo 
+				(SELECT COALESCE(u.photoThumb, u.photo)
 				FROM users u 
 				JOIN conversation_members cm2 
 				ON u.id = cm2.userId 
 				WHERE cm2.conversationId = c.id AND u.id != ?)
-			ELSE c.conversationPhoto
+			ELSE COALESCE(c.conversationPhotoThumb, c.conversationPhoto)
 		END AS conversation_photo,
-		(SELECT m.id FROM messages m WHERE m.conversationId = c.id ORDER BY m.timestamp DESC LIMIT 1) AS last_message_id,
-		(SELECT m.content FROM messages m WHERE m.conversationId = c.id ORDER BY m.timestamp DESC LIMIT 1) AS last_message_content,
-		(SELECT m.timestamp FROM messages m WHERE m.conversationId = c.id ORDER BY m.timestamp DESC LIMIT 1) AS last_message_timestamp,
-		(SELECT u.name FROM messages m 
-		JOIN users u ON m.senderId = u.id 
-		WHERE m.conversationId = c.id 
-		ORDER BY m.timestamp DESC LIMIT 1) AS last_message_sender_name,
-		(SELECT m.attachment FROM messages m   -- Fetch actual attachment data
-		WHERE m.conversationId = c.id 
-		ORDER BY m.timestamp DESC LIMIT 1) AS last_message_attachment
+		lm.id,
+		lm.content,
+		lm.timestamp AS last_message_timestamp,
+		lm.senderId,
+		lu.name,
+		IFNULL(lm.deletedAt, ''),
+		IFNULL(cm.lastReadMessageId, ''),
+		IFNULL(un.unread, 0)
 	FROM conversations c
 	JOIN conversation_members cm ON c.id = cm.conversationId
+	LEFT JOIN (` + unreadCounts + `) un ON un.conversationId = c.id
+	LEFT JOIN messages lm ON lm.id = (
+		SELECT m.id FROM messages m
+		WHERE m.conversationId = c.id
+			AND m.id NOT IN (SELECT messageId FROM hidden_messages WHERE userId = cm.userId)
+		ORDER BY m.timestamp DESC, m.id DESC LIMIT 1
+	)
+	LEFT JOIN users lu ON lm.senderId = lu.id
 	WHERE cm.userId = ?
 	ORDER BY last_message_timestamp DESC NULLS LAST;
     `
-	rows, err := db.c.Query(query, userID, userID, userID)
+	rows, err := db.c.Query(query, userID, userID, userID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching conversations: %w", err)
 	}
@@ -320,12 +551,13 @@ func (db *appdbimpl) GetMyConversations(userID string) ([]Conversation, error) {
 	for rows.Next() {
 		var conv Conversation
 		var (
-			lastMessageID         sql.NullString
-			lastMessageContent    sql.NullString
-			lastMessageTimestamp  sql.NullString
-			lastMessageSender     sql.NullString
-			lastMessageAttachment []byte
-			convPhoto             sql.NullString
+			lastMessageID        sql.NullString
+			lastMessageContent   sql.NullString
+			lastMessageTimestamp sql.NullString
+			lastMessageSenderID  sql.NullString
+			lastMessageSender    sql.NullString
+			lastMessageDeletedAt sql.NullString
+			convPhoto            sql.NullString
 		)
 		err := rows.Scan(
 			&conv.Id,
@@ -336,8 +568,11 @@ func (db *appdbimpl) GetMyConversations(userID string) ([]Conversation, error) {
 			&lastMessageID,
 			&lastMessageContent,
 			&lastMessageTimestamp,
+			&lastMessageSenderID,
 			&lastMessageSender,
-			&lastMessageAttachment,
+			&lastMessageDeletedAt,
+			&conv.LastReadMessageId,
+			&conv.UnreadCount,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning conversation: %w", err)
@@ -351,45 +586,145 @@ func (db *appdbimpl) GetMyConversations(userID string) ([]Conversation, error) {
 				Id:         lastMessageID.String,
 				Content:    lastMessageContent.String,
 				Timestamp:  lastMessageTimestamp.String,
+				SenderId:   lastMessageSenderID.String,
 				SenderName: lastMessageSender.String,
-				Attachment: lastMessageAttachment,
+				DeletedAt:  lastMessageDeletedAt.String,
 			}
 		}
-		members, err := db.GetConversationMembers(conv.Id)
-		if err != nil {
-			return nil, fmt.Errorf("error fetching conversation members: %w", err)
-		}
-		conv.Members = members
 		conversations = append(conversations, conv)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating over rows: %w", err)
 	}
+	var lastMessageIDs []string
+	for _, conv := range conversations {
+		if conv.LastMessage != nil {
+			lastMessageIDs = append(lastMessageIDs, conv.LastMessage.Id)
+		}
+	}
+	attachments, err := db.loadAttachments(lastMessageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching last message attachments: %w", err)
+	}
+	members, err := db.loadConversationMembers(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i, conv := range conversations {
+		if conv.LastMessage != nil {
+			conv.LastMessage.Attachments = attachments[conv.LastMessage.Id]
+		}
+		conversations[i].Members = members[conv.Id]
+	}
 	return conversations, nil
 }
 
+// loadConversationMembers returns the members of all the conversations of the user, by conversation ID, in one
+// query.
+func (db *appdbimpl) loadConversationMembers(userID string) (map[string][]string, error) {
+	rows, err := db.c.Query(`
+		SELECT o.conversationId, o.userId
+		FROM conversation_members o
+		JOIN conversation_members cm ON cm.conversationId = o.conversationId
+		WHERE cm.userId = ?
+		ORDER BY o.rowid
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching conversation members: %w", err)
+	}
+	defer rows.Close()
+	members := make(map[string][]string)
+	for rows.Next() {
+		var conversationID, memberID string
+		if err := rows.Scan(&conversationID, &memberID); err != nil {
+			return nil, fmt.Errorf("error scanning conversation member: %w", err)
+		}
+		members[conversationID] = append(members[conversationID], memberID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating conversation members: %w", err)
+	}
+	return members, nil
+}
+
+// DeleteMessage deletes a message of the user for everyone. The message stays as a tombstone, so that replies keep
+// pointing at it, but its content, attachments, edit history and reactions are removed. The files of the attachments
+// go too, unless forwarded copies of the message still carry them. Deleting a deleted message changes nothing.
 func (db *appdbimpl) DeleteMessage(conversationID, messageID, userID string) error {
-	var senderID string
+	var senderID, kind string
+	var deletedAt sql.NullString
 	err := db.c.QueryRow(`
-		SELECT senderId
+		SELECT senderId, kind, deletedAt
 		FROM messages
 		WHERE conversationId = ? AND id = ?
-	`, conversationID, messageID).Scan(&senderID)
+	`, conversationID, messageID).Scan(&senderID, &kind, &deletedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return ErrMessageDoesNotExist
 	}
 	if err != nil {
 		return fmt.Errorf("error fetching message: %w", err)
 	}
+	if kind == MessageKindSystem {
+		return ErrSystemMessage
+	}
 	if senderID != userID {
 		return ErrUnauthorizedToDeleteMessage
 	}
+	if deletedAt.Valid {
+		return nil
+	}
+	// Media carried by no other message, found before the attachments are removed.
+	orphanMedia := `
+		SELECT ma.mediaId FROM message_attachments ma
+		WHERE ma.messageId = ? AND NOT EXISTS (
+			SELECT 1 FROM message_attachments o WHERE o.mediaId = ma.mediaId AND o.messageId != ma.messageId
+		)`
+	cleanups := []string{
+		`DELETE FROM media_variants WHERE mediaId IN (` + orphanMedia + `)`,
+		`DELETE FROM media WHERE id IN (` + orphanMedia + `)`,
+		`DELETE FROM message_attachments WHERE messageId = ?`,
+		`DELETE FROM message_edits WHERE messageId = ?`,
+		`DELETE FROM reactions WHERE messageId = ?`,
+		`DELETE FROM comments WHERE messageId = ?`,
+	}
+	// A message is either left whole or turned into a tombstone, never stripped halfway.
+	return db.withTx(func(tx *appdbimpl) error {
+		for _, q := range cleanups {
+			if _, err := tx.c.Exec(q, messageID); err != nil {
+				return fmt.Errorf("error deleting message: %w", err)
+			}
+		}
+		_, err := tx.c.Exec(`
+			UPDATE messages
+			SET content = '', editedAt = NULL, deletedAt = ?
+			WHERE conversationId = ? AND id = ?
+		`, time.Now().UTC().Format(MessageTimestampFormat), conversationID, messageID)
+		if err != nil {
+			return fmt.Errorf("error deleting message: %w", err)
+		}
+		return nil
+	})
+}
+
+// HideMessage deletes a message for the user only: it is left out of what they read from then on. Any message of the
+// conversation can be hidden, including those of others.
+func (db *appdbimpl) HideMessage(conversationID, messageID, userID string) error {
+	var exists bool
+	err := db.c.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM messages WHERE conversationId = ? AND id = ?)
+	`, conversationID, messageID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking message existence: %w", err)
+	}
+	if !exists {
+		return ErrMessageDoesNotExist
+	}
 	_, err = db.c.Exec(`
-		DELETE FROM messages
-		WHERE conversationId = ? AND id = ?
-	`, conversationID, messageID)
+		INSERT OR IGNORE INTO hidden_messages (messageId, userId, hiddenAt)
+		VALUES (?, ?, ?)
+	`, messageID, userID, time.Now().UTC().Format(MessageTimestampFormat))
 	if err != nil {
-		return fmt.Errorf("error deleting message: %w", err)
+		return fmt.Errorf("error hiding message: %w", err)
 	}
 	return nil
 }
@@ -403,7 +738,7 @@ func (db *appdbimpl) GetMessage(messageID, userID string) (Message, error) {
             m.senderId, 
             m.content, 
             m.timestamp, 
-            m.attachment,
+            IFNULL(m.deletedAt, ''),
             u.name AS senderName
         FROM 
             messages m
@@ -419,7 +754,7 @@ func (db *appdbimpl) GetMessage(messageID, userID string) (Message, error) {
 		&message.SenderId,
 		&message.Content,
 		&message.Timestamp,
-		&message.Attachment,
+		&message.DeletedAt,
 		&message.SenderName,
 	)
 	if err == sql.ErrNoRows {
@@ -428,16 +763,10 @@ func (db *appdbimpl) GetMessage(messageID, userID string) (Message, error) {
 	if err != nil {
 		return message, fmt.Errorf("error fetching message: %w", err)
 	}
+	attachments, err := db.loadAttachments([]string{message.Id})
+	if err != nil {
+		return message, err
+	}
+	message.Attachments = attachments[message.Id]
 	return message, nil
 }
-
-func (db *appdbimpl) MarkMessagesAsRead(conversationID, userID string) error {
-	_, err := db.c.Exec(`
-        UPDATE read_receipts
-        SET readAt = CURRENT_TIMESTAMP
-        WHERE messageId IN (SELECT id FROM messages WHERE conversationId = ?)
-          AND userId = ?
-          AND readAt IS NULL
-    `, conversationID, userID)
-	return err
-}
@@ -0,0 +1,40 @@
+package database
+
+import "testing"
+
+func TestCreateDirectConversation(t *testing.T) {
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice", "bob")
+	if err := db.CreateDirectConversation("ab", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "conversation_members", "conversationId = 'ab'"); n != 2 {
+		t.Errorf("got %d members, want 2", n)
+	}
+}
+
+func TestCreateDirectConversationRollsBack(t *testing.T) {
+	tests := []struct {
+		name        string
+		recipientID string
+	}{
+		// The conversation row is written, then the members fail.
+		{"missing recipient", "nobody"},
+		{"sender as recipient", "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			createTestUsers(t, db, "alice")
+			if err := db.CreateDirectConversation("conv", "alice", tt.recipientID); err == nil {
+				t.Fatal("creating the conversation succeeded")
+			}
+			if n := countRows(t, db, "conversations", "id = 'conv'"); n != 0 {
+				t.Errorf("the conversation was kept")
+			}
+			if n := countRows(t, db, "conversation_members", "conversationId = 'conv'"); n != 0 {
+				t.Errorf("%d members were kept", n)
+			}
+		})
+	}
+}
@@ -8,5 +8,20 @@ var (
 	ErrMessageDoesNotExist         = errors.New("message does not exist")
 	ErrCommentDoesNotExist         = errors.New("comment does not exist")
 	ErrUnauthorizedToDeleteMessage = errors.New("unauthorized To Delete Message")
+	ErrUnauthorizedToEditMessage   = errors.New("unauthorized to edit message")
+	ErrUnauthorizedToChangeComment = errors.New("unauthorized to change comment")
+	ErrUnauthorizedToViewReceipts  = errors.New("unauthorized to view receipts")
+	ErrEditWindowExpired           = errors.New("message can no longer be edited")
+	ErrMessageDeleted              = errors.New("message was deleted")
+	ErrReplyNotInConversation      = errors.New("replied message is not in the conversation")
 	ErrGroupDoesNotExist           = errors.New("group does not exist")
+	ErrSessionDoesNotExist         = errors.New("session does not exist")
+	ErrUserNotInConversation       = errors.New("user is not a member of the conversation")
+	ErrUserAlreadyInConversation   = errors.New("user is already a member of the conversation")
+	ErrSystemMessage               = errors.New("system messages cannot be changed")
+	ErrMediaDoesNotExist           = errors.New("media does not exist")
+	ErrPhotoDoesNotExist           = errors.New("photo does not exist")
+	ErrSchemaTooNew                = errors.New("database schema is newer than this build")
+	ErrUnknownSchemaVersion        = errors.New("unknown schema version")
+	ErrIrreversibleMigration       = errors.New("migration cannot be rolled back")
 )
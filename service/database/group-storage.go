@@ -3,29 +3,37 @@ package database
 import (
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"strings"
 	"time"
 )
 
-func (db *appdbimpl) CreateGroupConversation(conversationID string, memberIDs []string, name string, photo []byte) error {
-	_, err := db.c.Exec(`
-        INSERT INTO conversations (id, name, type, created_at, conversationPhoto)
-        VALUES (?, ?, 'group', ?, ?)
-    `, conversationID, name, time.Now().Format(time.RFC3339), photo)
-	if err != nil {
-		return fmt.Errorf("error creating new conversation: %w", err)
-	}
-	for _, memberID := range memberIDs {
-		_, err = db.c.Exec(`
-            INSERT INTO conversation_members (conversationId, userId)
-            VALUES (?, ?)
-        `, conversationID, memberID)
+// CreateGroupConversation creates the group with all its members, or nothing if any of them cannot be added.
+func (db *appdbimpl) CreateGroupConversation(conversationID, ownerID string, memberIDs []string, name string, photo, photoThumb []byte) error {
+	createdAt := time.Now().UTC().Format(MessageTimestampFormat)
+	return db.withTx(func(tx *appdbimpl) error {
+		_, err := tx.c.Exec(`
+            INSERT INTO conversations (id, name, type, created_at, conversationPhoto, conversationPhotoThumb)
+            VALUES (?, ?, 'group', ?, ?, ?)
+        `, conversationID, name, createdAt, photo, photoThumb)
 		if err != nil {
-			return fmt.Errorf("error adding member %s to conversation_members: %w", memberID, err)
+			return fmt.Errorf("error creating new conversation: %w", err)
 		}
-	}
-	return nil
+		for _, memberID := range memberIDs {
+			role := RoleMember
+			if memberID == ownerID {
+				role = RoleOwner
+			}
+			_, err = tx.c.Exec(`
+                INSERT INTO conversation_members (conversationId, userId, role, joinedAt)
+                VALUES (?, ?, ?, ?)
+            `, conversationID, memberID, role, createdAt)
+			if err != nil {
+				return fmt.Errorf("error adding member %s to conversation_members: %w", memberID, err)
+			}
+		}
+		return nil
+	})
 }
 
 func (db *appdbimpl) GetMyGroups(userID string) ([]Conversation, error) {
@@ -33,7 +41,7 @@ func (db *appdbimpl) GetMyGroups(userID string) ([]Conversation, error) {
     SELECT 
         c.id,
         c.name,
-        c.conversationPhoto as photo
+        COALESCE(c.conversationPhotoThumb, c.conversationPhoto) as photo
     FROM conversations c
     JOIN conversation_members cm ON c.id = cm.conversationId
     WHERE cm.userId = ? AND c.type = 'group'
@@ -73,13 +81,11 @@ func (db *appdbimpl) GetMyGroups(userID string) ([]Conversation, error) {
 func (db *appdbimpl) GetGroupInfo(groupID string) (Conversation, error) {
 	var group Conversation
 	var photo []byte
-	var membersCSV sql.NullString
 	err := db.c.QueryRow(`
         SELECT 
             c.id,
             c.name,
-            c.conversationPhoto,
-            (SELECT GROUP_CONCAT(userId) FROM conversation_members WHERE conversationId = c.id) AS members
+            COALESCE(c.conversationPhotoThumb, c.conversationPhoto)
         FROM conversations c
         WHERE c.id = ? AND c.type = 'group'`,
 		groupID,
@@ -87,7 +93,6 @@ func (db *appdbimpl) GetGroupInfo(groupID string) (Conversation, error) {
 		&group.Id,
 		&group.Name,
 		&photo,
-		&membersCSV,
 	)
 	if err == sql.ErrNoRows {
 		return Conversation{}, ErrGroupDoesNotExist
@@ -103,16 +108,34 @@ func (db *appdbimpl) GetGroupInfo(groupID string) (Conversation, error) {
 	} else {
 		group.ConversationPhoto = sql.NullString{Valid: false}
 	}
-	if membersCSV.Valid {
-		group.Members = strings.Split(membersCSV.String, ",")
-	} else {
-		group.Members = []string{}
+	rows, err := db.c.Query(`
+        SELECT userId, role
+        FROM conversation_members
+        WHERE conversationId = ?`,
+		groupID,
+	)
+	if err != nil {
+		return Conversation{}, fmt.Errorf("error fetching group members: %w", err)
+	}
+	defer rows.Close()
+	group.Members = []string{}
+	group.Roles = map[string]string{}
+	for rows.Next() {
+		var userID, role string
+		if err := rows.Scan(&userID, &role); err != nil {
+			return Conversation{}, fmt.Errorf("error scanning group member: %w", err)
+		}
+		group.Members = append(group.Members, userID)
+		group.Roles[userID] = role
+	}
+	if err := rows.Err(); err != nil {
+		return Conversation{}, fmt.Errorf("error iterating group members: %w", err)
 	}
 	return group, nil
 }
 
 func (db *appdbimpl) UpdateGroupName(groupId, newName string) error {
-	res, err := db.c.Exec(`UPDATE conversations SET name=? WHERE id=?`, newName, groupId)
+	res, err := db.c.Exec(`UPDATE conversations SET name=? WHERE id=? AND type='group'`, newName, groupId)
 	if err != nil {
 		return err
 	}
@@ -120,44 +143,187 @@ func (db *appdbimpl) UpdateGroupName(groupId, newName string) error {
 	if err != nil {
 		return err
 	} else if affected == 0 {
-		return ErrUserDoesNotExist
+		return ErrGroupDoesNotExist
 	}
 	return nil
 }
 
-func (db *appdbimpl) UpdateGroupPhoto(groupID string, photo []byte) error {
-	var exists bool
-	err := db.c.QueryRow(`SELECT EXISTS(SELECT 1 FROM conversations WHERE id=?)`, groupID).Scan(&exists)
-	if err != nil {
+func (db *appdbimpl) UpdateGroupPhoto(groupID string, photo, photoThumb []byte) error {
+	if err := db.checkGroup(groupID); err != nil {
 		return err
 	}
-	if !exists {
-		return ErrGroupDoesNotExist
+	_, err := db.c.Exec(`UPDATE conversations SET conversationPhoto=?, conversationPhotoThumb=? WHERE id=?`, photo, photoThumb, groupID)
+	if err != nil {
+		return err
 	}
-	_, err = db.c.Exec(`UPDATE conversations SET conversationPhoto=? WHERE id=?`, photo, groupID)
+	return nil
+}
+
+// LeaveGroup removes the user from the group. If the group is left without an owner, the oldest admin, or failing that
+// the oldest member, becomes the owner so that the group stays manageable. The ID of the promoted user is returned, or
+// an empty string if nobody was promoted. Direct conversations cannot be left.
+func (db *appdbimpl) LeaveGroup(groupID, userID string) (string, error) {
+	var successorID string
+	err := db.withTx(func(tx *appdbimpl) error {
+		if err := tx.checkGroup(groupID); err != nil {
+			return err
+		}
+		res, err := tx.c.Exec(`
+		DELETE FROM conversation_members WHERE conversationId = ? AND userId = ?
+		`, groupID, userID)
+		if err != nil {
+			return fmt.Errorf("error leaving group: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrUserNotInConversation
+		}
+		var hasOwner bool
+		err = tx.c.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM conversation_members WHERE conversationId = ? AND role = ?)
+		`, groupID, RoleOwner).Scan(&hasOwner)
+		if err != nil {
+			return fmt.Errorf("error checking group owner: %w", err)
+		}
+		if hasOwner {
+			return nil
+		}
+		err = tx.c.QueryRow(`
+			SELECT userId
+			FROM conversation_members
+			WHERE conversationId = ?
+			ORDER BY CASE role WHEN ? THEN 0 ELSE 1 END, joinedAt, rowid
+			LIMIT 1
+		`, groupID, RoleAdmin).Scan(&successorID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error choosing group successor: %w", err)
+		}
+		return tx.SetMemberRole(groupID, successorID, RoleOwner)
+	})
 	if err != nil {
+		return "", err
+	}
+	return successorID, nil
+}
+
+// AddUserToGroup adds the user to the group. The messages sent before they joined do not count as unread. Direct
+// conversations keep their two members.
+func (db *appdbimpl) AddUserToGroup(conversationID string, userID string) error {
+	if err := db.checkGroup(conversationID); err != nil {
 		return err
 	}
+	var userExists, isMember bool
+	err := db.c.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM users WHERE id = ?),
+			EXISTS(SELECT 1 FROM conversation_members WHERE conversationId = ? AND userId = ?)
+	`, userID, conversationID, userID).Scan(&userExists, &isMember)
+	if err != nil {
+		return fmt.Errorf("error checking new group member: %w", err)
+	}
+	if !userExists {
+		return ErrUserDoesNotExist
+	}
+	if isMember {
+		return ErrUserAlreadyInConversation
+	}
+	now := time.Now().UTC().Format(MessageTimestampFormat)
+	// The read marker starts at the newest message, rather than at the time of joining, which messages sent within the
+	// same millisecond would sort after.
+	marker := MessageCursor{Timestamp: now}
+	err = db.c.QueryRow(`
+		SELECT timestamp, id FROM messages WHERE conversationId = ? ORDER BY timestamp DESC, id DESC LIMIT 1
+	`, conversationID).Scan(&marker.Timestamp, &marker.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error fetching newest message: %w", err)
+	}
+	_, err = db.c.Exec(`
+		INSERT INTO conversation_members (conversationId, userId, joinedAt, lastReadMessageId, lastReadTimestamp)
+		VALUES (?, ?, ?, ?, ?)
+	`, conversationID, userID, now, marker.Id, marker.Timestamp)
+	if err != nil {
+		return fmt.Errorf("error adding user to group: %w", err)
+	}
 	return nil
 }
 
-func (db *appdbimpl) LeaveGroup(groupID, userID string) error {
-	_, err := db.c.Exec(`
-	DELETE FROM conversation_members WHERE conversationId = ? AND userId = ?
+func (db *appdbimpl) RemoveUserFromGroup(groupID, userID string) error {
+	res, err := db.c.Exec(`
+		DELETE FROM conversation_members WHERE conversationId = ? AND userId = ?
 	`, groupID, userID)
 	if err != nil {
-		return fmt.Errorf("error leaving group: %w", err)
+		return fmt.Errorf("error removing user from group: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrUserNotInConversation
 	}
 	return nil
 }
 
-func (db *appdbimpl) AddUserToGroup(conversationID string, userID string) error {
-	_, err := db.c.Exec(
-		"INSERT INTO conversation_members (conversationId, userId) VALUES (?, ?)",
-		conversationID, userID,
-	)
+// TransferGroupOwnership makes toUserID the owner of the group. The previous owner stays in the group as an admin.
+func (db *appdbimpl) TransferGroupOwnership(groupID, fromUserID, toUserID string) error {
+	return db.withTx(func(tx *appdbimpl) error {
+		if err := tx.SetMemberRole(groupID, toUserID, RoleOwner); err != nil {
+			return err
+		}
+		return tx.SetMemberRole(groupID, fromUserID, RoleAdmin)
+	})
+}
+
+// GetMemberRole returns the role of the user in the group. Only groups have roles: ErrGroupDoesNotExist is returned
+// for direct conversations too.
+func (db *appdbimpl) GetMemberRole(conversationID, userID string) (string, error) {
+	if err := db.checkGroup(conversationID); err != nil {
+		return "", err
+	}
+	var role string
+	err := db.c.QueryRow(`
+		SELECT role FROM conversation_members WHERE conversationId = ? AND userId = ?
+	`, conversationID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotInConversation
+	}
 	if err != nil {
-		return fmt.Errorf("error adding user to group: %w", err)
+		return "", fmt.Errorf("error fetching member role: %w", err)
+	}
+	return role, nil
+}
+
+func (db *appdbimpl) SetMemberRole(conversationID, userID, role string) error {
+	res, err := db.c.Exec(`
+		UPDATE conversation_members SET role = ? WHERE conversationId = ? AND userId = ?
+	`, role, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("error updating member role: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrUserNotInConversation
+	}
+	return nil
+}
+
+// checkGroup returns ErrGroupDoesNotExist unless the conversation exists and is a group.
+func (db *appdbimpl) checkGroup(groupID string) error {
+	var isGroup bool
+	err := db.c.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM conversations WHERE id = ? AND type = 'group')
+	`, groupID).Scan(&isGroup)
+	if err != nil {
+		return fmt.Errorf("error checking group: %w", err)
+	}
+	if !isGroup {
+		return ErrGroupDoesNotExist
 	}
 	return nil
 }
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCreateGroupConversation(t *testing.T) {
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice", "bob", "carol")
+	if err := db.CreateGroupConversation("g", "alice", []string{"alice", "bob", "carol"}, "group", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "conversation_members", "conversationId = 'g'"); n != 3 {
+		t.Errorf("got %d members, want 3", n)
+	}
+	if n := countRows(t, db, "conversation_members", "conversationId = 'g' AND userId = 'alice' AND role = ?", RoleOwner); n != 1 {
+		t.Errorf("the creator is not the owner")
+	}
+}
+
+func TestCreateGroupConversationRollsBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+	}{
+		// The group and the members before the failing one are written first.
+		{"missing member", []string{"alice", "bob", "nobody", "carol"}},
+		{"duplicate member", []string{"alice", "bob", "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			createTestUsers(t, db, "alice", "bob", "carol")
+			if err := db.CreateGroupConversation("g", "alice", tt.members, "group", nil, nil); err == nil {
+				t.Fatal("creating the group succeeded")
+			}
+			if n := countRows(t, db, "conversations", "id = 'g'"); n != 0 {
+				t.Errorf("the group was kept")
+			}
+			if n := countRows(t, db, "conversation_members", "conversationId = 'g'"); n != 0 {
+				t.Errorf("%d members were kept", n)
+			}
+		})
+	}
+}
+
+func TestGroupChangesRefuseDirectConversations(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(db *appdbimpl) error
+	}{
+		{"leave", func(db *appdbimpl) error {
+			_, err := db.LeaveGroup("ab", "alice")
+			return err
+		}},
+		{"add member", func(db *appdbimpl) error { return db.AddUserToGroup("ab", "carol") }},
+		{"get role", func(db *appdbimpl) error {
+			_, err := db.GetMemberRole("ab", "alice")
+			return err
+		}},
+		{"rename", func(db *appdbimpl) error { return db.UpdateGroupName("ab", "group") }},
+		{"change photo", func(db *appdbimpl) error { return db.UpdateGroupPhoto("ab", []byte("photo"), nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			createTestUsers(t, db, "alice", "bob", "carol")
+			if err := db.CreateDirectConversation("ab", "alice", "bob"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.change(db); !errors.Is(err, ErrGroupDoesNotExist) {
+				t.Fatalf("got error %v, want %v", err, ErrGroupDoesNotExist)
+			}
+			if n := countRows(t, db, "conversation_members", "conversationId = 'ab' AND role = 'member'"); n != 2 {
+				t.Errorf("got %d plain members, want the 2 of the direct conversation", n)
+			}
+			if n := countRows(t, db, "conversations", "id = 'ab' AND name = '' AND conversationPhoto = ''"); n != 1 {
+				t.Error("the direct conversation was changed")
+			}
+		})
+	}
+}
+
+func TestAddUserToGroupChecksTheUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		wantErr error
+	}{
+		{"new member", "carol", nil},
+		{"unknown user", "nobody", ErrUserDoesNotExist},
+		{"already a member", "bob", ErrUserAlreadyInConversation},
+		{"empty user ID", "", ErrUserDoesNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			createTestUsers(t, db, "alice", "bob", "carol")
+			if err := db.CreateGroupConversation("g", "alice", []string{"alice", "bob"}, "group", nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.AddUserToGroup("g", tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLeaveGroupNotAMember(t *testing.T) {
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice", "bob", "carol")
+	if err := db.CreateGroupConversation("g", "alice", []string{"alice", "bob"}, "group", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LeaveGroup("g", "carol"); !errors.Is(err, ErrUserNotInConversation) {
+		t.Errorf("got error %v, want %v", err, ErrUserNotInConversation)
+	}
+	if n := countRows(t, db, "conversation_members", "conversationId = 'g' AND role = ?", RoleOwner); n != 1 {
+		t.Errorf("got %d owners, want 1", n)
+	}
+}
@@ -4,41 +4,86 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 )
 
 type AppDatabase interface {
 	Ping() error
+	WithTx(fn func(tx AppDatabase) error) error
 	GetUserByName(name string) (User, error)
+	GetUserById(id string) (User, error)
 	CreateUser(u User) (User, error)
 	UpdateUserName(userId string, newName string) (User, error)
-	UpdateUserPhoto(userID string, photo []byte) error
+	UpdateUserPhoto(userID string, photo, photoThumb []byte) error
+	GetUserPasswordHash(userID string) (string, error)
+	SetUserPasswordHash(userID, passwordHash string) error
 	SearchUsersByName(username string) ([]User, error)
+	SearchMessages(query MessageSearch) (MessageSearchPage, error)
 	GetDirectConversation(senderID, recipientID string) (string, error)
 	CreateDirectConversation(conversationID, senderID, recipientID string) error
-	SaveMessage(conversationID, senderID, messageID, content string, attachment []byte, replyTo string) (Message, error)
-	InsertDeliveryReceipt(messageID, userID, deliveredAt string) error
+	SaveMessage(conversationID, senderID, messageID, content string, attachments []Attachment, replyTo string) (Message, error)
+	SaveMedia(m Media, data []byte) error
+	GetMedia(mediaID string) (Media, error)
+	GetMediaContent(mediaID string) ([]byte, error)
+	CanUserAccessMedia(mediaID, userID string) (bool, error)
+	SaveMediaVariant(mediaID string, variant MediaVariant) error
+	GetMediaVariant(mediaID, name string) (MediaVariant, error)
+	SaveSystemMessage(conversationID, messageID, content string, event SystemEvent) (Message, error)
+	InsertReceipt(messageID, userID string) error
+	MarkMessagesDelivered(conversationID, userID, messageID string) (bool, error)
+	MarkMessagesRead(conversationID, userID, messageID string) (bool, error)
+	GetUnreadCount(userID string) (UnreadCount, error)
+	GetMessageReceipts(conversationID, messageID, userID string) ([]Receipt, error)
 	IsUserInConversation(conversationID, userID string) (bool, error)
 	GetConversationDetails(conversationID, currentUserID string) (Conversation, error)
-	GetMessagesForConversation(conversationID string) ([]Message, error)
+	GetMessages(conversationID string, query MessageQuery) (MessagePage, error)
+	GetMessagesAround(conversationID, messageID, userID string, limit int) (MessagePage, error)
 	GetMyConversations(userID string) ([]Conversation, error)
 	GetConversationMembers(conversationID string) ([]string, error)
 	GetUsersPhoto(userID string) (User, error)
+	GetConversationPhoto(conversationID, currentUserID string) ([]byte, error)
 	DeleteMessage(conversationID, messageID, userID string) error
+	HideMessage(conversationID, messageID, userID string) error
+	EditMessage(conversationID, messageID, userID, content string, window time.Duration) (Message, error)
+	GetMessageEdits(conversationID, messageID string) ([]MessageEdit, error)
 	GetMessage(messageID, userID string) (Message, error)
-	CreateGroupConversation(conversationID string, memberIDs []string, name string, photo []byte) error
+	CreateGroupConversation(conversationID, ownerID string, memberIDs []string, name string, photo, photoThumb []byte) error
 	GetMyGroups(userID string) ([]Conversation, error)
 	GetGroupInfo(groupID string) (Conversation, error)
 	UpdateGroupName(groupId, newName string) error
-	UpdateGroupPhoto(groupID string, photo []byte) error
-	LeaveGroup(groupID, userID string) error
+	UpdateGroupPhoto(groupID string, photo, photoThumb []byte) error
+	LeaveGroup(groupID, userID string) (string, error)
 	AddUserToGroup(conversationID string, userID string) error
-	CommentMessage(commentID, messageID, authorID string) error
-	UncommentMessage(messageID, authorID string) error
-	MarkMessagesAsRead(conversationID, userID string) error
+	RemoveUserFromGroup(groupID, userID string) error
+	TransferGroupOwnership(groupID, fromUserID, toUserID string) error
+	GetMemberRole(conversationID, userID string) (string, error)
+	SetMemberRole(conversationID, userID, role string) error
+	AddReaction(conversationID, messageID, userID, emoji string) error
+	RemoveReaction(conversationID, messageID, userID, emoji string) error
+	AddComment(conversationID, messageID, commentID, authorID, content string) (Comment, error)
+	EditComment(conversationID, messageID, commentID, userID, content string) (Comment, error)
+	DeleteComment(conversationID, messageID, commentID, userID string) error
+	GetComments(conversationID, messageID string, query CommentQuery) (CommentPage, error)
+	CreateSession(s Session) error
+	GetSessionByTokenHash(tokenHash string) (Session, error)
+	GetUserSessions(userID string) ([]Session, error)
+	DeleteSession(sessionID, userID string) error
+	DeleteOtherSessions(userID, keepSessionID string) error
+}
+
+// dbConn runs queries, either on the database or in a transaction.
+type dbConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
 type appdbimpl struct {
-	c *sql.DB
+	c dbConn
+	// conn is the database behind c, which transactions are started on.
+	conn *sql.DB
+	// fts tells whether messages are searched through the full-text index.
+	fts bool
 }
 
 func New(db *sql.DB) (AppDatabase, error) {
@@ -49,76 +94,42 @@ func New(db *sql.DB) (AppDatabase, error) {
 	if err != nil {
 		return nil, err
 	}
-	var tableName string
-	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='users';`).Scan(&tableName)
-	if errors.Is(err, sql.ErrNoRows) {
-		usersTable := `CREATE TABLE users (
-			id TEXT NOT NULL PRIMARY KEY,
-			name TEXT NOT NULL UNIQUE,
-			photo BLOB
-		);`
-		conversationsTable := `CREATE TABLE conversations (
-			id TEXT NOT NULL PRIMARY KEY,
-			name TEXT NOT NULL,
-			type TEXT NOT NULL,
-			created_at TEXT NOT NULL,
-			conversationPhoto BLOB
-		);`
-		conversationMembersTable := `CREATE TABLE conversation_members (
-			conversationId TEXT NOT NULL,
-			userId TEXT NOT NULL,
-			FOREIGN KEY (conversationId) REFERENCES conversations(id) ON DELETE CASCADE,
-			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE,
-			PRIMARY KEY(conversationId, userId)
-		);`
-		messagesTable := `CREATE TABLE messages (
-			id TEXT NOT NULL PRIMARY KEY,
-			conversationId TEXT NOT NULL,
-			senderId TEXT NOT NULL,
-			content TEXT NOT NULL,
-			timestamp TEXT NOT NULL,
-			attachment BLOB,
-			replyTo TEXT,  
-			FOREIGN KEY (conversationId) REFERENCES conversations(id) ON DELETE CASCADE,
-			FOREIGN KEY (senderId) REFERENCES users(id) ON DELETE CASCADE
-		);`
-		commentsTable := `CREATE TABLE comments (
-			id TEXT NOT NULL PRIMARY KEY,
-			messageId TEXT NOT NULL,
-			authorId TEXT NOT NULL,
-			UNIQUE(messageId, authorId),
-			FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
-			FOREIGN KEY (authorId) REFERENCES users(id) ON DELETE CASCADE
-		);`
-		readReceiptsTable := `CREATE TABLE read_receipts (
-			messageId TEXT NOT NULL,
-			userId TEXT NOT NULL,
-			deliveredAt TEXT NOT NULL,
-			readAt TEXT, 
-			PRIMARY KEY (messageId, userId),
-			FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
-			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
-		);`
-		creationQueries := []string{
-			usersTable,
-			conversationsTable,
-			conversationMembersTable,
-			messagesTable,
-			commentsTable,
-			readReceiptsTable,
-		}
-		for _, q := range creationQueries {
-			_, execErr := db.Exec(q)
-			if execErr != nil {
-				return nil, fmt.Errorf("error creating database structure: %w", execErr)
-			}
-		}
-	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+	if err := Migrate(db); err != nil {
 		return nil, err
 	}
-	return &appdbimpl{c: db}, nil
+	fts, err := setupMessageSearch(db)
+	if err != nil {
+		return nil, err
+	}
+	return &appdbimpl{c: db, conn: db, fts: fts}, nil
 }
 
 func (db *appdbimpl) Ping() error {
-	return db.c.Ping()
+	return db.conn.Ping()
+}
+
+// WithTx runs fn with a database whose operations all belong to one transaction: it is committed if fn succeeds,
+// and rolled back if fn fails, so that none of its writes are kept.
+func (db *appdbimpl) WithTx(fn func(tx AppDatabase) error) error {
+	return db.withTx(func(tx *appdbimpl) error { return fn(tx) })
+}
+
+// withTx is WithTx for the methods of the database. Transactions do not nest: called within one, it runs fn in it.
+func (db *appdbimpl) withTx(fn func(tx *appdbimpl) error) error {
+	if _, ok := db.c.(*sql.Tx); ok {
+		return fn(db)
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	// Rolling back after the commit does nothing.
+	defer func() { _ = tx.Rollback() }()
+	if err := fn(&appdbimpl{c: tx, conn: db.conn, fts: db.fts}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var errInjected = errors.New("injected failure")
+
+// newTestDatabase returns a migrated database in a temporary file. Foreign keys are enforced on every connection, so
+// that writes violating them fail whichever connection runs them.
+func newTestDatabase(t *testing.T) *appdbimpl {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.(*appdbimpl)
+}
+
+func createTestUsers(t *testing.T, db AppDatabase, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if _, err := db.CreateUser(User{Id: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// countRows returns how many rows of the table match the condition.
+func countRows(t *testing.T, db *appdbimpl, table, condition string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := db.c.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE `+condition, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWithTxCommits(t *testing.T) {
+	db := newTestDatabase(t)
+	err := db.WithTx(func(tx AppDatabase) error {
+		createTestUsers(t, tx, "alice", "bob")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "users", "1"); n != 2 {
+		t.Errorf("got %d users, want 2", n)
+	}
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	db := newTestDatabase(t)
+	err := db.WithTx(func(tx AppDatabase) error {
+		createTestUsers(t, tx, "alice", "bob")
+		return errInjected
+	})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("got error %v, want %v", err, errInjected)
+	}
+	if n := countRows(t, db, "users", "1"); n != 0 {
+		t.Errorf("got %d users after rollback, want 0", n)
+	}
+}
+
+func TestWithTxJoinsOuterTransaction(t *testing.T) {
+	db := newTestDatabase(t)
+	err := db.withTx(func(outer *appdbimpl) error {
+		err := outer.withTx(func(inner *appdbimpl) error {
+			if inner.c != outer.c {
+				t.Error("nested transaction does not run in the outer one")
+			}
+			createTestUsers(t, inner, "alice")
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// The inner call committed nothing: failing now takes its writes back too.
+		return errInjected
+	})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("got error %v, want %v", err, errInjected)
+	}
+	if n := countRows(t, db, "users", "1"); n != 0 {
+		t.Errorf("got %d users after rollback, want 0", n)
+	}
+}
+
+func TestWithTxRollsBackFailedStatement(t *testing.T) {
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice")
+	err := db.WithTx(func(tx AppDatabase) error {
+		if err := tx.UpdateUserPhoto("alice", []byte("photo"), nil); err != nil {
+			return err
+		}
+		// The conversation does not exist.
+		return tx.AddUserToGroup("missing", "alice")
+	})
+	if err == nil {
+		t.Fatal("adding a member to a missing conversation succeeded")
+	}
+	if n := countRows(t, db, "users", "photo IS NOT NULL"); n != 0 {
+		t.Errorf("the photo written before the failure was kept")
+	}
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// mediaColumns are the metadata columns of the media table, in the order scanned by scanMedia.
+const mediaColumns = `id, fileName, mimeType, size, width, height, sha256, uploaderId, createdAt`
+
+func scanMedia(row interface{ Scan(...interface{}) error }) (Media, error) {
+	var m Media
+	err := row.Scan(&m.Id, &m.FileName, &m.MimeType, &m.Size, &m.Width, &m.Height, &m.Sha256, &m.UploaderId, &m.CreatedAt)
+	return m, err
+}
+
+func (db *appdbimpl) SaveMedia(m Media, data []byte) error {
+	_, err := db.c.Exec(`
+		INSERT INTO media (id, fileName, mimeType, size, width, height, sha256, uploaderId, createdAt, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, m.Id, m.FileName, m.MimeType, m.Size, m.Width, m.Height, m.Sha256, m.UploaderId, m.CreatedAt, data)
+	if err != nil {
+		return fmt.Errorf("error saving media: %w", err)
+	}
+	return nil
+}
+
+func (db *appdbimpl) GetMedia(mediaID string) (Media, error) {
+	m, err := scanMedia(db.c.QueryRow(`SELECT `+mediaColumns+` FROM media WHERE id = ?`, mediaID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Media{}, ErrMediaDoesNotExist
+	} else if err != nil {
+		return Media{}, fmt.Errorf("error fetching media: %w", err)
+	}
+	return m, nil
+}
+
+func (db *appdbimpl) GetMediaContent(mediaID string) ([]byte, error) {
+	var data []byte
+	err := db.c.QueryRow(`SELECT data FROM media WHERE id = ?`, mediaID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaDoesNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("error fetching media content: %w", err)
+	}
+	return data, nil
+}
+
+func (db *appdbimpl) SaveMediaVariant(mediaID string, v MediaVariant) error {
+	_, err := db.c.Exec(`
+		INSERT INTO media_variants (mediaId, variant, mimeType, width, height, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, mediaID, v.Name, v.MimeType, v.Width, v.Height, v.Data)
+	if err != nil {
+		return fmt.Errorf("error saving media variant: %w", err)
+	}
+	return nil
+}
+
+// GetMediaVariant returns a downscaled copy of the media. ErrMediaDoesNotExist is returned when no such variant was
+// built, e.g. because the original is already small enough.
+func (db *appdbimpl) GetMediaVariant(mediaID, name string) (MediaVariant, error) {
+	v := MediaVariant{Name: name}
+	err := db.c.QueryRow(`
+		SELECT mimeType, width, height, data
+		FROM media_variants
+		WHERE mediaId = ? AND variant = ?
+	`, mediaID, name).Scan(&v.MimeType, &v.Width, &v.Height, &v.Data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MediaVariant{}, ErrMediaDoesNotExist
+	} else if err != nil {
+		return MediaVariant{}, fmt.Errorf("error fetching media variant: %w", err)
+	}
+	return v, nil
+}
+
+// CanUserAccessMedia reports whether the user uploaded the media or is a member of a conversation where a message
+// carries it.
+func (db *appdbimpl) CanUserAccessMedia(mediaID, userID string) (bool, error) {
+	var allowed bool
+	err := db.c.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM media WHERE id = ? AND uploaderId = ?)
+			OR EXISTS(
+				SELECT 1 FROM message_attachments ma
+				JOIN messages m ON m.id = ma.messageId
+				JOIN conversation_members cm ON cm.conversationId = m.conversationId
+				WHERE ma.mediaId = ? AND cm.userId = ?
+			)
+	`, mediaID, userID, mediaID, userID).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("error checking media access: %w", err)
+	}
+	return allowed, nil
+}
+
+func (db *appdbimpl) saveAttachments(messageID string, attachments []Attachment) error {
+	for position, a := range attachments {
+		_, err := db.c.Exec(`
+			INSERT INTO message_attachments (messageId, position, mediaId, caption)
+			VALUES (?, ?, ?, ?)
+		`, messageID, position, a.Id, a.Caption)
+		if err != nil {
+			return fmt.Errorf("error saving message attachment: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadAttachments returns the attachments of the given messages, in order, by message ID. Messages without
+// attachments are left out of the map.
+func (db *appdbimpl) loadAttachments(messageIDs []string) (map[string][]Attachment, error) {
+	attachments := make(map[string][]Attachment)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(messageIDs)), ", ")
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := db.c.Query(`
+		SELECT ma.messageId, ma.caption, md.id, md.fileName, md.mimeType, md.size, md.width, md.height
+		FROM message_attachments ma
+		JOIN media md ON md.id = ma.mediaId
+		WHERE ma.messageId IN (`+placeholders+`)
+		ORDER BY ma.messageId, ma.position
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching message attachments: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageID string
+		var a Attachment
+		err := rows.Scan(&messageID, &a.Caption, &a.Id, &a.FileName, &a.MimeType, &a.Size, &a.Width, &a.Height)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning message attachment: %w", err)
+		}
+		attachments[messageID] = append(attachments[messageID], a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message attachments: %w", err)
+	}
+	return attachments, nil
+}
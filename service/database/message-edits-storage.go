@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EditMessage replaces the content of a message of the user, sent no longer than window ago, and returns the edited
+// message. The content it replaces is kept as a revision. Editing to the same content changes nothing.
+func (db *appdbimpl) EditMessage(conversationID, messageID, userID, content string, window time.Duration) (Message, error) {
+	var senderID, kind, oldContent, timestamp string
+	var editedAt, deletedAt sql.NullString
+	err := db.c.QueryRow(`
+		SELECT senderId, kind, content, timestamp, editedAt, deletedAt
+		FROM messages
+		WHERE conversationId = ? AND id = ?
+	`, conversationID, messageID).Scan(&senderID, &kind, &oldContent, &timestamp, &editedAt, &deletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, ErrMessageDoesNotExist
+	} else if err != nil {
+		return Message{}, fmt.Errorf("error fetching message: %w", err)
+	}
+	if kind == MessageKindSystem {
+		return Message{}, ErrSystemMessage
+	}
+	if senderID != userID {
+		return Message{}, ErrUnauthorizedToEditMessage
+	}
+	if deletedAt.Valid {
+		return Message{}, ErrMessageDeleted
+	}
+	sentAt, err := time.Parse(MessageTimestampFormat, timestamp)
+	if err != nil {
+		return Message{}, fmt.Errorf("error parsing message timestamp: %w", err)
+	}
+	now := time.Now().UTC()
+	if now.Sub(sentAt) > window {
+		return Message{}, ErrEditWindowExpired
+	}
+
+	if content != oldContent {
+		// The replaced content was written when the message was sent, or by the previous edit.
+		createdAt := timestamp
+		if editedAt.Valid {
+			createdAt = editedAt.String
+		}
+		replacedAt := now.Format(MessageTimestampFormat)
+		err = db.withTx(func(tx *appdbimpl) error {
+			_, err := tx.c.Exec(`
+				INSERT INTO message_edits (messageId, revision, content, createdAt, replacedAt)
+				SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?
+				FROM message_edits
+				WHERE messageId = ?
+			`, messageID, oldContent, createdAt, replacedAt, messageID)
+			if err != nil {
+				return fmt.Errorf("error saving message revision: %w", err)
+			}
+			_, err = tx.c.Exec(`UPDATE messages SET content = ?, editedAt = ? WHERE id = ?`, content, replacedAt, messageID)
+			if err != nil {
+				return fmt.Errorf("error editing message: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return Message{}, err
+		}
+	}
+
+	messages, err := db.loadMessages([]string{messageID})
+	if err != nil {
+		return Message{}, err
+	}
+	if len(messages) == 0 {
+		return Message{}, ErrMessageDoesNotExist
+	}
+	return messages[0], nil
+}
+
+// GetMessageEdits returns the past revisions of a message of the conversation, oldest first. Messages that were never
+// edited have none.
+func (db *appdbimpl) GetMessageEdits(conversationID, messageID string) ([]MessageEdit, error) {
+	var exists bool
+	err := db.c.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM messages WHERE conversationId = ? AND id = ?)
+	`, conversationID, messageID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("error checking message existence: %w", err)
+	}
+	if !exists {
+		return nil, ErrMessageDoesNotExist
+	}
+	rows, err := db.c.Query(`
+		SELECT revision, content, createdAt, replacedAt
+		FROM message_edits
+		WHERE messageId = ?
+		ORDER BY revision
+	`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching message revisions: %w", err)
+	}
+	defer rows.Close()
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var e MessageEdit
+		if err := rows.Scan(&e.Revision, &e.Content, &e.CreatedAt, &e.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("error scanning message revision: %w", err)
+		}
+		edits = append(edits, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message revisions: %w", err)
+	}
+	return edits, nil
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration is a versioned change of the schema. Its version is its position in the migrations list, starting at 1,
+// and is recorded in the user_version pragma of the database once applied. Migrations check the schema before
+// changing it, so that databases created before versioning, with any part of the schema already there, are brought
+// up to date too.
+type migration struct {
+	name string
+	up   func(tx *sql.Tx) error
+	// down reverts up; it is nil when the migration cannot be rolled back.
+	down func(tx *sql.Tx) error
+}
+
+// MigrationStatus tells whether a migration is applied to a database.
+type MigrationStatus struct {
+	Version    int
+	Name       string
+	Applied    bool
+	Reversible bool
+}
+
+// LatestSchemaVersion returns the version of the schema this build works with.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// SchemaVersion returns the version of the schema of the database, 0 when no migration was ever applied.
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// GetMigrationStatus lists the migrations of this build, telling which ones are applied to the database.
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i] = MigrationStatus{
+			Version:    i + 1,
+			Name:       m.name,
+			Applied:    i+1 <= version,
+			Reversible: m.down != nil,
+		}
+	}
+	return status, nil
+}
+
+// Migrate applies the pending migrations to the database. It fails on databases migrated by a newer build, rather
+// than running with a schema it does not know.
+func Migrate(db *sql.DB) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("%w: version %d, this build knows up to %d", ErrSchemaTooNew, version, len(migrations))
+	}
+	return MigrateTo(db, len(migrations))
+}
+
+// MigrateTo applies or rolls back migrations, one transaction each, until the schema of the database is at the
+// given version. Rolling back past a migration that cannot be reverted fails before any is rolled back.
+func MigrateTo(db *sql.DB, target int) error {
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("%w: %d", ErrUnknownSchemaVersion, target)
+	}
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("%w: version %d, this build knows up to %d", ErrSchemaTooNew, version, len(migrations))
+	}
+	if lowest := LowestReachableVersion(version); target < lowest {
+		m := migrations[lowest-1]
+		return fmt.Errorf("%w: %d (%s), the schema can go down to version %d", ErrIrreversibleMigration, lowest,
+			m.name, lowest)
+	}
+	for ; version < target; version++ {
+		if err := runMigration(db, version, version+1, migrations[version].up); err != nil {
+			return fmt.Errorf("error applying migration %d (%s): %w", version+1, migrations[version].name, err)
+		}
+	}
+	for ; version > target; version-- {
+		m := migrations[version-1]
+		if err := runMigration(db, version, version-1, m.down); err != nil {
+			return fmt.Errorf("error rolling back migration %d (%s): %w", version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// LowestReachableVersion returns the lowest version the schema can be rolled back to from the given one: the version
+// of the newest migration up to it that cannot be reverted, or 0 if all of them can.
+func LowestReachableVersion(version int) int {
+	if version > len(migrations) {
+		version = len(migrations)
+	}
+	for ; version > 0; version-- {
+		if migrations[version-1].down == nil {
+			return version
+		}
+	}
+	return 0
+}
+
+// runMigration moves the schema from a version to the next or the previous one in a transaction. The version is
+// checked again inside it, in case another process migrated the database meanwhile.
+func runMigration(db *sql.DB, from, to int, step func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version != from {
+		return fmt.Errorf("schema version changed to %d while migrating", version)
+	}
+	if err := step(tx); err != nil {
+		return err
+	}
+	// Pragmas take no parameters; the version is an integer of ours.
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, to)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execAll runs the statements in order, stopping at the first failure.
+func execAll(tx *sql.Tx, queries ...string) error {
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)`, table, column).Scan(&exists)
+	return exists, err
+}
+
+// addColumn adds a column to a table, unless the table already has it.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
+
+// dropColumn removes a column from a table, if the table has it.
+func dropColumn(tx *sql.Tx, table, column string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE ` + table + ` DROP COLUMN ` + column)
+	return err
+}
@@ -0,0 +1,334 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestConn returns an empty database in a temporary file, not migrated.
+func newTestConn(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func schemaVersion(t *testing.T, conn *sql.DB) int {
+	t.Helper()
+	version, err := SchemaVersion(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+// schema returns the definition of every table, index and trigger of the database, by name.
+func schema(t *testing.T, conn *sql.DB) map[string]string {
+	t.Helper()
+	rows, err := conn.Query(`SELECT name, IFNULL(sql, '') FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	definitions := make(map[string]string)
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			t.Fatal(err)
+		}
+		definitions[name] = definition
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return definitions
+}
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+	conn := newTestConn(t)
+	if version := schemaVersion(t, conn); version != 0 {
+		t.Fatalf("an empty database is at version %d", version)
+	}
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	if version := schemaVersion(t, conn); version != LatestSchemaVersion() {
+		t.Errorf("got version %d, want %d", version, LatestSchemaVersion())
+	}
+	status, err := GetMigrationStatus(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if !s.Applied {
+			t.Errorf("migration %d (%s) is not applied", s.Version, s.Name)
+		}
+	}
+	migrated := schema(t, conn)
+	for _, table := range []string{"users", "messages", "sessions", "media", "message_attachments", "reactions"} {
+		if migrated[table] == "" {
+			t.Errorf("table %s is missing", table)
+		}
+	}
+	// Migrating a database already up to date changes nothing.
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	if again := schema(t, conn); !reflect.DeepEqual(again, migrated) {
+		t.Error("migrating again changed the schema")
+	}
+}
+
+// legacySchema is the schema databases were created with before their version was recorded.
+var legacySchema = []string{
+	`CREATE TABLE users (
+		id TEXT NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		photo BLOB
+	);`,
+	`CREATE TABLE conversations (
+		id TEXT NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		conversationPhoto BLOB
+	);`,
+	`CREATE TABLE conversation_members (
+		conversationId TEXT NOT NULL,
+		userId TEXT NOT NULL,
+		FOREIGN KEY (conversationId) REFERENCES conversations(id) ON DELETE CASCADE,
+		FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY(conversationId, userId)
+	);`,
+	`CREATE TABLE messages (
+		id TEXT NOT NULL PRIMARY KEY,
+		conversationId TEXT NOT NULL,
+		senderId TEXT NOT NULL,
+		content TEXT NOT NULL,
+		timestamp TEXT NOT NULL,
+		attachment BLOB,
+		replyTo TEXT,
+		FOREIGN KEY (conversationId) REFERENCES conversations(id) ON DELETE CASCADE,
+		FOREIGN KEY (senderId) REFERENCES users(id) ON DELETE CASCADE
+	);`,
+	`CREATE TABLE comments (
+		id TEXT NOT NULL PRIMARY KEY,
+		messageId TEXT NOT NULL,
+		authorId TEXT NOT NULL,
+		UNIQUE(messageId, authorId),
+		FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (authorId) REFERENCES users(id) ON DELETE CASCADE
+	);`,
+	`CREATE TABLE read_receipts (
+		messageId TEXT NOT NULL,
+		userId TEXT NOT NULL,
+		deliveredAt TEXT NOT NULL,
+		readAt TEXT,
+		PRIMARY KEY (messageId, userId),
+		FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+	);`,
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	conn := newTestConn(t)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	queries := append(append([]string{}, legacySchema...),
+		`INSERT INTO users (id, name) VALUES ('alice', 'alice'), ('bob', 'bob'), ('carol', 'carol')`,
+		`INSERT INTO conversations (id, name, type, created_at) VALUES ('g', 'group', 'group', '2024-05-01T12:00:00+02:00')`,
+		`INSERT INTO conversation_members (conversationId, userId) VALUES ('g', 'alice'), ('g', 'bob'), ('g', 'carol')`,
+		`INSERT INTO messages (id, conversationId, senderId, content, timestamp, replyTo) VALUES
+			('m1', 'g', 'bob', 'first', '2024-05-01T10:00:00.000Z', ''),
+			('m2', 'g', 'alice', 'second', '2024-05-01T10:01:00.000Z', 'm1')`,
+		`INSERT INTO read_receipts (messageId, userId, deliveredAt, readAt) VALUES
+			('m1', 'alice', '2024-05-01T10:00:01Z', '2024-05-01T10:00:02Z'),
+			('m2', 'bob', '2024-05-01T10:01:01Z', NULL)`,
+		`INSERT INTO comments (id, messageId, authorId) VALUES ('c1', 'm1', 'carol')`,
+	)
+	for _, q := range queries {
+		if _, err := conn.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.Exec(`UPDATE messages SET attachment = ? WHERE id = 'm2'`, png); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	if version := schemaVersion(t, conn); version != LatestSchemaVersion() {
+		t.Errorf("got version %d, want %d", version, LatestSchemaVersion())
+	}
+	// The legacy database ends up with the schema of a new one.
+	fresh := newTestConn(t)
+	if err := Migrate(fresh); err != nil {
+		t.Fatal(err)
+	}
+	legacyColumns, freshColumns := tableColumns(t, conn), tableColumns(t, fresh)
+	if !reflect.DeepEqual(legacyColumns, freshColumns) {
+		t.Errorf("got tables %v, want %v", legacyColumns, freshColumns)
+	}
+
+	db, err := New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := db.GetMessages("g", MessageQuery{Limit: 10, UserID: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(page.Messages))
+	}
+	first, second := page.Messages[0], page.Messages[1]
+	if first.Id != "m1" || first.Status != MessageStatusRead {
+		t.Errorf("got message %s with status %s, want m1 read", first.Id, first.Status)
+	}
+	if second.Id != "m2" || second.Status != MessageStatusDelivered {
+		t.Errorf("got message %s with status %s, want m2 delivered", second.Id, second.Status)
+	}
+	if len(second.Attachments) != 1 || second.Attachments[0].MimeType != "image/png" {
+		t.Errorf("got attachments %+v, want the PNG moved to media", second.Attachments)
+	}
+	// The first sender of the group became its owner.
+	role, err := db.GetMemberRole("g", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != RoleOwner {
+		t.Errorf("got role %s for the first sender, want %s", role, RoleOwner)
+	}
+	// What the members read or sent does not count as unread.
+	for user, want := range map[string]int{"alice": 0, "bob": 1, "carol": 2} {
+		count, err := db.GetUnreadCount(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count.Messages != want {
+			t.Errorf("%s has %d unread messages, want %d", user, count.Messages, want)
+		}
+	}
+}
+
+// tableColumns returns the columns of every table of the database, by table name.
+func tableColumns(t *testing.T, conn *sql.DB) map[string][]string {
+	t.Helper()
+	rows, err := conn.Query(`
+		SELECT t.name, c.name
+		FROM sqlite_master t, pragma_table_info(t.name) c
+		WHERE t.type = 'table' AND t.name NOT LIKE 'sqlite_%' AND t.name NOT LIKE 'messages_fts%'
+		ORDER BY t.name, c.name
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columns := make(map[string][]string)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			t.Fatal(err)
+		}
+		columns[table] = append(columns[table], column)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return columns
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	conn := newTestConn(t)
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	migrated := tableColumns(t, conn)
+	lowest := LowestReachableVersion(LatestSchemaVersion())
+	if lowest == 0 || migrations[lowest-1].down != nil {
+		t.Fatalf("got %d as the lowest version, which is not that of an irreversible migration", lowest)
+	}
+	for version := LatestSchemaVersion() - 1; version >= lowest; version-- {
+		if err := MigrateTo(conn, version); err != nil {
+			t.Fatal(err)
+		}
+		if got := schemaVersion(t, conn); got != version {
+			t.Fatalf("got version %d, want %d", got, version)
+		}
+	}
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	if got := tableColumns(t, conn); !reflect.DeepEqual(got, migrated) {
+		t.Errorf("got tables %v after rolling back and migrating again, want %v", got, migrated)
+	}
+}
+
+func TestMigrateToRefuses(t *testing.T) {
+	conn := newTestConn(t)
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	before := schema(t, conn)
+	latest := LatestSchemaVersion()
+	lowest := LowestReachableVersion(latest)
+	tests := []struct {
+		name    string
+		target  int
+		wantErr error
+	}{
+		{"past an irreversible migration", lowest - 1, ErrIrreversibleMigration},
+		{"to nothing", 0, ErrIrreversibleMigration},
+		{"below 0", -1, ErrUnknownSchemaVersion},
+		{"past the latest", latest + 1, ErrUnknownSchemaVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := MigrateTo(conn, tt.target); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			// Nothing was rolled back before refusing.
+			if version := schemaVersion(t, conn); version != latest {
+				t.Errorf("got version %d, want %d", version, latest)
+			}
+			if got := schema(t, conn); !reflect.DeepEqual(got, before) {
+				t.Error("the schema changed")
+			}
+		})
+	}
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	conn := newTestConn(t)
+	if _, err := conn.Exec(`PRAGMA user_version = 1000`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(conn); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("got error %v, want %v", err, ErrSchemaTooNew)
+	}
+	if err := MigrateTo(conn, 0); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("got error %v rolling back, want %v", err, ErrSchemaTooNew)
+	}
+}
+
+func TestLowestReachableVersion(t *testing.T) {
+	for version := 0; version <= LatestSchemaVersion()+1; version++ {
+		lowest := LowestReachableVersion(version)
+		if lowest > version {
+			t.Errorf("from %d: got %d", version, lowest)
+		}
+		for v := lowest + 1; v <= version && v <= len(migrations); v++ {
+			if migrations[v-1].down == nil {
+				t.Errorf("from %d: got %d, but migration %d cannot be rolled back", version, lowest, v)
+			}
+		}
+	}
+}
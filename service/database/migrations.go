@@ -0,0 +1,605 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"mime"
+	"net/http"
+)
+
+// migrations lists the changes of the schema in the order they are applied. Append new ones at the end, and never
+// change the ones already released. The full-text index of messages depends on how SQLite was built, so opening the
+// database also adapts it to the build (see setupMessageSearch).
+var migrations = []migration{
+	{name: "initial schema", up: createInitialSchema},
+	{name: "sessions", up: createSessions, down: dropSessions},
+	{name: "user passwords", up: addUserPasswords, down: dropUserPasswords},
+	{name: "group member roles", up: addMemberRoles, down: dropMemberRoles},
+	{name: "system messages", up: addSystemMessages, down: dropSystemMessages},
+	{name: "message order", up: addMessageOrder, down: dropMessageOrder},
+	{name: "media", up: moveAttachmentsToMedia},
+	{name: "message edits", up: addMessageEdits, down: dropMessageEdits},
+	{name: "message tombstones", up: addMessageTombstones, down: dropMessageTombstones},
+	{name: "reactions", up: addReactions, down: dropReactions},
+	{name: "comment threads", up: addCommentThreads, down: dropCommentThreads},
+	{name: "delivery acknowledgements", up: addDeliveryAcknowledgements, down: dropDeliveryAcknowledgements},
+	{name: "read markers", up: addReadMarkers, down: dropReadMarkers},
+	{name: "UTC join times", up: convertJoinTimesToUTC, down: keepJoinTimes},
+	{name: "media times", up: convertMediaTimes, down: keepMediaTimes},
+	{name: "message search", up: addMessageSearch, down: dropMessageSearch},
+}
+
+// createInitialSchema creates the tables of the first release. Comments were anonymous likes then, and messages held
+// their attachment.
+func createInitialSchema(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS users (
+			id TEXT NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			photo BLOB
+		);`,
+		`CREATE TABLE IF NOT EXISTS conversations (
+			id TEXT NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			conversationPhoto BLOB
+		);`,
+		`CREATE TABLE IF NOT EXISTS conversation_members (
+			conversationId TEXT NOT NULL,
+			userId TEXT NOT NULL,
+			FOREIGN KEY (conversationId) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE,
+			PRIMARY KEY(conversationId, userId)
+		);`,
+		`CREATE TABLE IF NOT EXISTS messages (
+			id TEXT NOT NULL PRIMARY KEY,
+			conversationId TEXT NOT NULL,
+			senderId TEXT NOT NULL,
+			content TEXT NOT NULL,
+			timestamp TEXT NOT NULL,
+			attachment BLOB,
+			replyTo TEXT,
+			FOREIGN KEY (conversationId) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (senderId) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS comments (
+			id TEXT NOT NULL PRIMARY KEY,
+			messageId TEXT NOT NULL,
+			authorId TEXT NOT NULL,
+			UNIQUE(messageId, authorId),
+			FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (authorId) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS read_receipts (
+			messageId TEXT NOT NULL,
+			userId TEXT NOT NULL,
+			deliveredAt TEXT NOT NULL,
+			readAt TEXT,
+			PRIMARY KEY (messageId, userId),
+			FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+	)
+}
+
+func createSessions(tx *sql.Tx) error {
+	return execAll(tx, `CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT NOT NULL PRIMARY KEY,
+		userId TEXT NOT NULL,
+		tokenHash TEXT NOT NULL UNIQUE,
+		createdAt TEXT NOT NULL,
+		expiresAt TEXT NOT NULL,
+		FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+}
+
+func dropSessions(tx *sql.Tx) error {
+	return execAll(tx, `DROP TABLE IF EXISTS sessions`)
+}
+
+func addUserPasswords(tx *sql.Tx) error {
+	return addColumn(tx, "users", "passwordHash", "TEXT")
+}
+
+func dropUserPasswords(tx *sql.Tx) error {
+	return dropColumn(tx, "users", "passwordHash")
+}
+
+// addMemberRoles gives group members a role. Groups did not record who created them, so the author of the first
+// message of each group, or else its first member, becomes its owner.
+func addMemberRoles(tx *sql.Tx) error {
+	if err := addColumn(tx, "conversation_members", "role", "TEXT NOT NULL DEFAULT '"+RoleMember+"'"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		UPDATE conversation_members SET role = ?
+		WHERE rowid IN (
+			SELECT memberRowid FROM (
+				SELECT memberRowid, ROW_NUMBER() OVER (
+					PARTITION BY conversationId ORDER BY isFirstSender DESC, memberRowid
+				) AS rank
+				FROM (
+					SELECT cm.rowid AS memberRowid, cm.conversationId, cm.userId = (
+						SELECT m.senderId FROM messages m
+						WHERE m.conversationId = cm.conversationId
+						ORDER BY m.timestamp, m.id
+						LIMIT 1
+					) AS isFirstSender
+					FROM conversation_members cm
+					JOIN conversations c ON c.id = cm.conversationId
+					WHERE c.type = 'group'
+					  AND NOT EXISTS (
+						SELECT 1 FROM conversation_members o WHERE o.conversationId = c.id AND o.role = ?)
+				)
+			)
+			WHERE rank = 1
+		)
+	`, RoleOwner, RoleOwner)
+	return err
+}
+
+func dropMemberRoles(tx *sql.Tx) error {
+	return dropColumn(tx, "conversation_members", "role")
+}
+
+func addSystemMessages(tx *sql.Tx) error {
+	columns := [][3]string{
+		{"conversation_members", "joinedAt", "TEXT NOT NULL DEFAULT ''"},
+		{"messages", "kind", "TEXT NOT NULL DEFAULT '" + MessageKindUser + "'"},
+		{"messages", "eventType", "TEXT"},
+		{"messages", "eventTargetId", "TEXT"},
+		{"messages", "eventValue", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, c[0], c[1], c[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropSystemMessages deletes the system messages, which would read as user messages without their kind.
+func dropSystemMessages(tx *sql.Tx) error {
+	if exists, err := columnExists(tx, "messages", "kind"); err != nil {
+		return err
+	} else if exists {
+		if _, err := tx.Exec(`DELETE FROM messages WHERE kind = ?`, MessageKindSystem); err != nil {
+			return err
+		}
+	}
+	for _, c := range [][2]string{
+		{"messages", "eventValue"},
+		{"messages", "eventTargetId"},
+		{"messages", "eventType"},
+		{"messages", "kind"},
+		{"conversation_members", "joinedAt"},
+	} {
+		if err := dropColumn(tx, c[0], c[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMessageOrder indexes messages in the order they are paged through. Pages compare timestamps as text, so the
+// ones written with a local offset are converted to UTC in the message timestamp format.
+func addMessageOrder(tx *sql.Tx) error {
+	return execAll(tx,
+		`UPDATE messages SET timestamp = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', timestamp), timestamp)`,
+		`CREATE INDEX IF NOT EXISTS messages_conversation_order ON messages (conversationId, timestamp, id);`,
+	)
+}
+
+func dropMessageOrder(tx *sql.Tx) error {
+	return execAll(tx, `DROP INDEX IF EXISTS messages_conversation_order`)
+}
+
+// moveAttachmentsToMedia stores attachments, and thumbnails of photos, apart from messages. The attachment held by a
+// message becomes a media of its sender, with the ID of the message; its size in pixels is left unknown, and it has
+// no thumbnails, which clients do without.
+func moveAttachmentsToMedia(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE IF NOT EXISTS media (
+			id TEXT NOT NULL PRIMARY KEY,
+			fileName TEXT NOT NULL DEFAULT '',
+			mimeType TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			width INTEGER NOT NULL DEFAULT 0,
+			height INTEGER NOT NULL DEFAULT 0,
+			sha256 TEXT NOT NULL,
+			uploaderId TEXT NOT NULL,
+			createdAt TEXT NOT NULL,
+			data BLOB NOT NULL,
+			FOREIGN KEY (uploaderId) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS media_variants (
+			mediaId TEXT NOT NULL,
+			variant TEXT NOT NULL,
+			mimeType TEXT NOT NULL,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			data BLOB NOT NULL,
+			PRIMARY KEY (mediaId, variant),
+			FOREIGN KEY (mediaId) REFERENCES media(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS message_attachments (
+			messageId TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			mediaId TEXT NOT NULL,
+			caption TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (messageId, position),
+			FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (mediaId) REFERENCES media(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS message_attachments_media ON message_attachments (mediaId);`,
+	)
+	if err != nil {
+		return err
+	}
+	columns := [][3]string{
+		{"media", "fileName", "TEXT NOT NULL DEFAULT ''"},
+		{"media", "sha256", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "photoThumb", "BLOB"},
+		{"conversations", "conversationPhotoThumb", "BLOB"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, c[0], c[1], c[2]); err != nil {
+			return err
+		}
+	}
+	if exists, err := columnExists(tx, "messages", "attachment"); err != nil {
+		return err
+	} else if exists {
+		if err := moveMessageAttachments(tx); err != nil {
+			return err
+		}
+		if err := dropColumn(tx, "messages", "attachment"); err != nil {
+			return err
+		}
+	}
+	// Messages referenced a single media before they could have several attachments.
+	if exists, err := columnExists(tx, "messages", "mediaId"); err != nil {
+		return err
+	} else if exists {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO message_attachments (messageId, position, mediaId)
+			SELECT id, 0, mediaId FROM messages WHERE mediaId IS NOT NULL
+		`)
+		if err != nil {
+			return err
+		}
+		return dropColumn(tx, "messages", "mediaId")
+	}
+	return nil
+}
+
+func moveMessageAttachments(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id FROM messages WHERE length(attachment) > 0`)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+	// Attachments are loaded one at a time, since they can be large.
+	for _, id := range ids {
+		var senderID, timestamp string
+		var data []byte
+		err := tx.QueryRow(`SELECT senderId, timestamp, attachment FROM messages WHERE id = ?`, id).
+			Scan(&senderID, &timestamp, &data)
+		if err != nil {
+			return err
+		}
+		mimeType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+		if err != nil {
+			mimeType = "application/octet-stream"
+		}
+		sum := sha256.Sum256(data)
+		_, err = tx.Exec(`
+			INSERT INTO media (id, mimeType, size, sha256, uploaderId, createdAt, data)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, id, mimeType, len(data), hex.EncodeToString(sum[:]), senderID, timestamp, data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO message_attachments (messageId, position, mediaId) VALUES (?, 0, ?)`, id, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addMessageEdits(tx *sql.Tx) error {
+	if err := addColumn(tx, "messages", "editedAt", "TEXT"); err != nil {
+		return err
+	}
+	return execAll(tx, `CREATE TABLE IF NOT EXISTS message_edits (
+		messageId TEXT NOT NULL,
+		revision INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		createdAt TEXT NOT NULL,
+		replacedAt TEXT NOT NULL,
+		PRIMARY KEY (messageId, revision),
+		FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE
+	);`)
+}
+
+// dropMessageEdits forgets the edit history; edited messages keep their current content.
+func dropMessageEdits(tx *sql.Tx) error {
+	if err := execAll(tx, `DROP TABLE IF EXISTS message_edits`); err != nil {
+		return err
+	}
+	return dropColumn(tx, "messages", "editedAt")
+}
+
+func addMessageTombstones(tx *sql.Tx) error {
+	if err := addColumn(tx, "messages", "deletedAt", "TEXT"); err != nil {
+		return err
+	}
+	return execAll(tx, `CREATE TABLE IF NOT EXISTS hidden_messages (
+		messageId TEXT NOT NULL,
+		userId TEXT NOT NULL,
+		hiddenAt TEXT NOT NULL,
+		PRIMARY KEY (userId, messageId),
+		FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+}
+
+// dropMessageTombstones deletes the tombstones of the messages deleted for everyone, as messages were deleted before.
+// Messages hidden by a user show again.
+func dropMessageTombstones(tx *sql.Tx) error {
+	if exists, err := columnExists(tx, "messages", "deletedAt"); err != nil {
+		return err
+	} else if exists {
+		if _, err := tx.Exec(`DELETE FROM messages WHERE deletedAt IS NOT NULL`); err != nil {
+			return err
+		}
+	}
+	if err := execAll(tx, `DROP TABLE IF EXISTS hidden_messages`); err != nil {
+		return err
+	}
+	return dropColumn(tx, "messages", "deletedAt")
+}
+
+// legacyLikeEmoji is the reaction the anonymous likes of the first release become.
+const legacyLikeEmoji = "❤️"
+
+// addReactions replaces the anonymous likes, kept in the comments table, by emoji reactions.
+func addReactions(tx *sql.Tx) error {
+	err := execAll(tx, `CREATE TABLE IF NOT EXISTS reactions (
+		messageId TEXT NOT NULL,
+		userId TEXT NOT NULL,
+		emoji TEXT NOT NULL,
+		createdAt TEXT NOT NULL,
+		PRIMARY KEY (messageId, userId, emoji),
+		FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		return err
+	}
+	// Comment threads use the table name too; only likes have no content.
+	likes, err := columnExists(tx, "comments", "authorId")
+	if err != nil {
+		return err
+	}
+	threads, err := columnExists(tx, "comments", "content")
+	if err != nil || !likes || threads {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO reactions (messageId, userId, emoji, createdAt)
+		SELECT c.messageId, c.authorId, ?, m.timestamp
+		FROM comments c
+		JOIN messages m ON m.id = c.messageId
+	`, legacyLikeEmoji)
+	if err != nil {
+		return err
+	}
+	return execAll(tx, `DROP TABLE comments`)
+}
+
+// dropReactions turns back the reactions into likes, one per user and message whatever the emoji.
+func dropReactions(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE comments (
+			id TEXT NOT NULL PRIMARY KEY,
+			messageId TEXT NOT NULL,
+			authorId TEXT NOT NULL,
+			UNIQUE(messageId, authorId),
+			FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (authorId) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`INSERT INTO comments (id, messageId, authorId)
+		SELECT lower(hex(randomblob(16))), messageId, userId FROM reactions GROUP BY messageId, userId`,
+		`DROP TABLE reactions`,
+	)
+}
+
+func addCommentThreads(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS comments (
+			id TEXT PRIMARY KEY,
+			messageId TEXT NOT NULL,
+			authorId TEXT NOT NULL,
+			content TEXT NOT NULL,
+			createdAt TEXT NOT NULL,
+			editedAt TEXT,
+			FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (authorId) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS comments_message_order ON comments (messageId, createdAt, id);`,
+	)
+}
+
+func dropCommentThreads(tx *sql.Tx) error {
+	return execAll(tx, `DROP TABLE IF EXISTS comments`)
+}
+
+// addDeliveryAcknowledgements lets receipts wait for the recipient to acknowledge the delivery, instead of being
+// delivered when written. SQLite cannot drop a NOT NULL constraint, so the table is rebuilt; its times, written with a
+// local offset, are converted to the message timestamp format along.
+func addDeliveryAcknowledgements(tx *sql.Tx) error {
+	var notNull bool
+	err := tx.QueryRow(`SELECT "notnull" FROM pragma_table_info('read_receipts') WHERE name = 'deliveredAt'`).
+		Scan(&notNull)
+	if err != nil || !notNull {
+		return err
+	}
+	return rebuildReadReceipts(tx, "TEXT",
+		"COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', r.deliveredAt), r.deliveredAt)",
+		"COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', r.readAt), r.readAt)")
+}
+
+// dropDeliveryAcknowledgements marks the receipts not acknowledged yet as delivered when read, or else when sent.
+func dropDeliveryAcknowledgements(tx *sql.Tx) error {
+	return rebuildReadReceipts(tx, "TEXT NOT NULL", "COALESCE(r.deliveredAt, r.readAt, m.timestamp)", "r.readAt")
+}
+
+func rebuildReadReceipts(tx *sql.Tx, deliveredAtType, deliveredAt, readAt string) error {
+	return execAll(tx,
+		`CREATE TABLE read_receipts_rebuilt (
+			messageId TEXT NOT NULL,
+			userId TEXT NOT NULL,
+			deliveredAt `+deliveredAtType+`,
+			readAt TEXT,
+			PRIMARY KEY (messageId, userId),
+			FOREIGN KEY (messageId) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`INSERT INTO read_receipts_rebuilt (messageId, userId, deliveredAt, readAt)
+		SELECT r.messageId, r.userId, `+deliveredAt+`, `+readAt+`
+		FROM read_receipts r
+		JOIN messages m ON m.id = r.messageId`,
+		`DROP TABLE read_receipts`,
+		`ALTER TABLE read_receipts_rebuilt RENAME TO read_receipts`,
+	)
+}
+
+// addReadMarkers adds the read marker of each member, set at the newest message the member read or sent, so that
+// the messages already read do not count as unread.
+func addReadMarkers(tx *sql.Tx) error {
+	if err := addColumn(tx, "conversation_members", "lastReadMessageId", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumn(tx, "conversation_members", "lastReadTimestamp", "TEXT"); err != nil {
+		return err
+	}
+	return execAll(tx, `
+		UPDATE conversation_members SET (lastReadTimestamp, lastReadMessageId) = (
+			SELECT m.timestamp, m.id
+			FROM messages m
+			WHERE m.conversationId = conversation_members.conversationId
+			  AND (m.senderId = conversation_members.userId OR EXISTS (
+				SELECT 1 FROM read_receipts r
+				WHERE r.messageId = m.id AND r.userId = conversation_members.userId AND r.readAt IS NOT NULL))
+			ORDER BY m.timestamp DESC, m.id DESC
+			LIMIT 1
+		)
+		WHERE lastReadTimestamp IS NULL
+	`)
+}
+
+func dropReadMarkers(tx *sql.Tx) error {
+	if err := dropColumn(tx, "conversation_members", "lastReadTimestamp"); err != nil {
+		return err
+	}
+	return dropColumn(tx, "conversation_members", "lastReadMessageId")
+}
+
+// convertJoinTimesToUTC converts the creation times of conversations and the join times of members written with a
+// local offset to the message timestamp format, as successors are chosen by comparing join times as text.
+func convertJoinTimesToUTC(tx *sql.Tx) error {
+	return execAll(tx,
+		`UPDATE conversations SET created_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', created_at), created_at)`,
+		`UPDATE conversation_members SET joinedAt = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', joinedAt), joinedAt)`,
+	)
+}
+
+// keepJoinTimes leaves the converted times as they are: older builds read them just as well.
+func keepJoinTimes(tx *sql.Tx) error {
+	return nil
+}
+
+// convertMediaTimes brings the upload times of media written in RFC 3339 to the message timestamp format, which the
+// media moved out of messages already have, so that they compare with the times of messages.
+func convertMediaTimes(tx *sql.Tx) error {
+	return execAll(tx,
+		`UPDATE media SET createdAt = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', createdAt), createdAt)`,
+	)
+}
+
+// keepMediaTimes leaves the converted times as they are: older builds parse them as RFC 3339 times.
+func keepMediaTimes(tx *sql.Tx) error {
+	return nil
+}
+
+// messageSearchTriggers keep the full-text index of message content in sync with the messages table.
+var messageSearchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts (rowid, content) VALUES (new.rowid, new.content);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+		INSERT INTO messages_fts (rowid, content) VALUES (new.rowid, new.content);
+	END;`,
+}
+
+// addMessageSearch creates the full-text index of message content with the triggers keeping it in sync, and indexes
+// the messages already there. The index needs SQLite built with FTS5: without it, nothing is created, and searches
+// fall back to LIKE.
+func addMessageSearch(tx *sql.Tx) error {
+	available, err := fts5Available(tx)
+	if err != nil || !available {
+		return err
+	}
+	queries := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5 (
+			content,
+			content = 'messages',
+			content_rowid = 'rowid',
+			tokenize = 'unicode61 remove_diacritics 2'
+		);`,
+	}
+	queries = append(queries, messageSearchTriggers...)
+	queries = append(queries, `INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');`)
+	return execAll(tx, queries...)
+}
+
+// dropMessageSearch removes the full-text index. Without FTS5, SQLite cannot drop the index table, so only its
+// triggers go; the table is left unused.
+func dropMessageSearch(tx *sql.Tx) error {
+	if err := dropMessageSearchTriggers(tx); err != nil {
+		return err
+	}
+	available, err := fts5Available(tx)
+	if err != nil || !available {
+		return err
+	}
+	return execAll(tx, `DROP TABLE IF EXISTS messages_fts`)
+}
+
+func dropMessageSearchTriggers(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TRIGGER IF EXISTS messages_fts_insert`,
+		`DROP TRIGGER IF EXISTS messages_fts_delete`,
+		`DROP TRIGGER IF EXISTS messages_fts_update`,
+	)
+}
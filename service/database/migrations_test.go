@@ -0,0 +1,45 @@
+package database
+
+import "testing"
+
+func TestConvertMediaTimes(t *testing.T) {
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice")
+	tests := []struct {
+		createdAt string
+		want      string
+	}{
+		{"2025-03-01T10:20:30Z", "2025-03-01T10:20:30.000Z"},
+		{"2025-03-01T12:20:30+02:00", "2025-03-01T10:20:30.000Z"},
+		{"2025-03-01T10:20:30.123Z", "2025-03-01T10:20:30.123Z"},
+	}
+	for i, tt := range tests {
+		_, err := db.c.Exec(`
+			INSERT INTO media (id, mimeType, size, sha256, uploaderId, createdAt, data)
+			VALUES (?, 'text/plain', 1, '', 'alice', ?, x'00')
+		`, i, tt.createdAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := convertMediaTimes(tx); err != nil {
+		_ = tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		var got string
+		if err := db.c.QueryRow(`SELECT createdAt FROM media WHERE id = ?`, i).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.createdAt, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,46 @@ package database
 
 import "database/sql"
 
+// Roles of the members of a group conversation. The owner has every admin permission.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Kinds of messages. System messages are written by the server to record changes to the conversation, and their
+// sender is the user who made the change.
+const (
+	MessageKindUser   = "user"
+	MessageKindSystem = "system"
+)
+
+// Statuses of a message, from the acknowledgements of its recipients: sent until every recipient acknowledged its
+// delivery, then delivered until every recipient read it.
+const (
+	MessageStatusSent      = "sent"
+	MessageStatusDelivered = "delivered"
+	MessageStatusRead      = "read"
+)
+
+// Types of the events recorded by system messages.
+const (
+	EventMemberAdded      = "member_added"
+	EventMemberLeft       = "member_left"
+	EventMemberRemoved    = "member_removed"
+	EventAdminPromoted    = "admin_promoted"
+	EventAdminDemoted     = "admin_demoted"
+	EventOwnerTransferred = "owner_transferred"
+	EventOwnerSucceeded   = "owner_succeeded"
+	EventGroupRenamed     = "group_renamed"
+	EventGroupPhoto       = "group_photo_changed"
+)
+
 type User struct {
-	Id    string `json:"id"`
-	Name  string `json:"name"`
-	Photo []byte `json:"photo,omitempty"`
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Photo      []byte `json:"photo,omitempty"`
+	PhotoThumb []byte `json:"-"`
 }
 
 type Group struct {
@@ -15,37 +51,209 @@ type Group struct {
 }
 
 type Conversation struct {
-	Id                string         `json:"id"`
-	Name              string         `json:"name"`
-	Type              string         `json:"type"`
-	CreatedAt         string         `json:"createdAt"`
-	Members           []string       `json:"members"`
-	LastMessage       *Message       `json:"lastMessage,omitempty"`
-	Messages          []Message      `json:"messages,omitempty"`
-	ConversationPhoto sql.NullString `json:"conversationPhoto,omitempty"`
+	Id                string            `json:"id"`
+	Name              string            `json:"name"`
+	Type              string            `json:"type"`
+	CreatedAt         string            `json:"createdAt"`
+	Members           []string          `json:"members"`
+	Roles             map[string]string `json:"roles,omitempty"`
+	LastMessage       *Message          `json:"lastMessage,omitempty"`
+	LastReadMessageId string            `json:"lastReadMessageId,omitempty"`
+	UnreadCount       int               `json:"unreadCount"`
+	Messages          []Message         `json:"messages,omitempty"`
+	PrevCursor        string            `json:"prevCursor,omitempty"`
+	NextCursor        string            `json:"nextCursor,omitempty"`
+	ConversationPhoto sql.NullString    `json:"conversationPhoto,omitempty"`
+}
+
+// UnreadCount is how many messages a user has not read, in all their conversations, and in how many conversations.
+type UnreadCount struct {
+	Messages      int `json:"unreadCount"`
+	Conversations int `json:"unreadConversations"`
+}
+
+// MessageTimestampFormat is the format of message timestamps. Being fixed width, UTC and with milliseconds, they sort
+// chronologically as strings, which message pages rely on.
+const MessageTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// MessageEdit is a past revision of the content of a message: what it read from CreatedAt, when it was written, until
+// ReplacedAt, when it was edited.
+type MessageEdit struct {
+	Revision   int    `json:"revision"`
+	Content    string `json:"content"`
+	CreatedAt  string `json:"createdAt"`
+	ReplacedAt string `json:"replacedAt"`
+}
+
+// MessageCursor is the position of a message in the chronological order of a conversation.
+type MessageCursor struct {
+	Timestamp string
+	Id        string
+}
+
+// MessageQuery selects a page of messages: the newest ones, or those right before or after a cursor.
+type MessageQuery struct {
+	Before *MessageCursor
+	After  *MessageCursor
+	Limit  int
+	// UserID is the reader: the messages they hid are left out.
+	UserID string
+}
+
+// MessageSearch selects a page of the messages of a user's conversations containing all the terms, newest first,
+// optionally in one conversation, from a timestamp (inclusive) and until another (exclusive), and before a cursor.
+type MessageSearch struct {
+	UserID         string
+	Terms          []string
+	ConversationID string
+	From           string
+	Until          string
+	Before         *MessageCursor
+	Limit          int
+}
+
+// MessageMatch is a message found by a search, with the conversation it is in and an excerpt of its content. The
+// snippet is HTML: the content is escaped, and the matched terms are wrapped in mark elements.
+type MessageMatch struct {
+	MessageId        string `json:"messageId"`
+	ConversationId   string `json:"conversationId"`
+	ConversationName string `json:"conversationName"`
+	ConversationType string `json:"conversationType"`
+	SenderId         string `json:"senderId"`
+	SenderName       string `json:"senderName"`
+	Timestamp        string `json:"timestamp"`
+	Snippet          string `json:"snippet"`
+}
+
+// MessageSearchPage is a page of search results, telling whether there are older matches beyond it.
+type MessageSearchPage struct {
+	Matches []MessageMatch
+	HasMore bool
+}
+
+// MessagePage is a window of a conversation's messages in chronological order, telling whether there are older or
+// newer messages beyond it.
+type MessagePage struct {
+	Messages []Message
+	HasOlder bool
+	HasNewer bool
 }
 
 type Message struct {
-	Id                string   `json:"id"`
-	ConversationId    string   `json:"conversationId"`
-	SenderId          string   `json:"senderId"`
-	SenderName        string   `json:"senderName"`
-	Content           string   `json:"content"`
-	Timestamp         string   `json:"timestamp"`
-	Attachment        []byte   `json:"attachment"`
-	SenderPhoto       string   `json:"senderPhoto,omitempty"`
-	ReactionCount     int      `json:"reactionCount"`
-	ReactingUserNames []string `json:"reactingUserNames"`
-	Status            string   `json:"status"`
-	ReplyTo           string   `json:"replyTo,omitempty"`
-	ReplyContent      string   `json:"replyContent,omitempty"`
-	ReplySenderName   string   `json:"replySenderName,omitempty"`
-	ReplyAttachment   []byte   `json:"replyAttachment,omitempty"`
+	Id               string       `json:"id"`
+	ConversationId   string       `json:"conversationId"`
+	Kind             string       `json:"kind"`
+	SenderId         string       `json:"senderId"`
+	SenderName       string       `json:"senderName"`
+	Content          string       `json:"content"`
+	Timestamp        string       `json:"timestamp"`
+	EditedAt         string       `json:"editedAt,omitempty"`
+	DeletedAt        string       `json:"deletedAt,omitempty"`
+	Attachments      []Attachment `json:"attachments,omitempty"`
+	SenderPhoto      string       `json:"senderPhoto,omitempty"`
+	Reactions        []Reaction   `json:"reactions"`
+	CommentCount     int          `json:"commentCount"`
+	Status           string       `json:"status"`
+	ReplyTo          string       `json:"replyTo,omitempty"`
+	ReplyContent     string       `json:"replyContent,omitempty"`
+	ReplySenderName  string       `json:"replySenderName,omitempty"`
+	ReplyDeleted     bool         `json:"replyDeleted,omitempty"`
+	ReplyAttachments []Attachment `json:"replyAttachments,omitempty"`
+	Event            *SystemEvent `json:"event,omitempty"`
+}
+
+// Media is the metadata of a stored attachment. Listings carry only this; the content is fetched separately.
+type Media struct {
+	Id         string `json:"id"`
+	FileName   string `json:"fileName,omitempty"`
+	MimeType   string `json:"mimeType"`
+	Size       int64  `json:"size"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Sha256     string `json:"-"`
+	UploaderId string `json:"-"`
+	CreatedAt  string `json:"-"`
+}
+
+// Attachment is a file of a message, with its caption. A message carries its attachments in the order they were
+// sent.
+type Attachment struct {
+	Media
+	Caption string `json:"caption,omitempty"`
+}
+
+// Reaction is the emoji reactions of one kind on a message, in the order the users reacted.
+type Reaction struct {
+	Emoji     string   `json:"emoji"`
+	Count     int      `json:"count"`
+	UserIds   []string `json:"userIds"`
+	UserNames []string `json:"userNames"`
+}
+
+// MediaVariant is a downscaled copy of an image attachment, built when the attachment is stored.
+type MediaVariant struct {
+	Name     string
+	MimeType string
+	Width    int
+	Height   int
+	Data     []byte
+}
+
+// SystemEvent is the structured content of a system message, so that clients can render or localize it. The actor
+// is the sender of the message; the target, if any, is the user the change was applied to.
+type SystemEvent struct {
+	Type       string `json:"type"`
+	ActorId    string `json:"actorId"`
+	ActorName  string `json:"actorName"`
+	TargetId   string `json:"targetId,omitempty"`
+	TargetName string `json:"targetName,omitempty"`
+	Value      string `json:"value,omitempty"`
 }
 
+// Comment is a text comment in the thread of a message.
 type Comment struct {
-	Id       string `json:"id"`
-	AuthorId string `json:"authorId"`
+	Id         string `json:"id"`
+	MessageId  string `json:"messageId"`
+	AuthorId   string `json:"authorId"`
+	AuthorName string `json:"authorName"`
+	Content    string `json:"content"`
+	CreatedAt  string `json:"createdAt"`
+	EditedAt   string `json:"editedAt,omitempty"`
+}
+
+// CommentCursor is the position of a comment in the chronological order of a thread.
+type CommentCursor struct {
+	CreatedAt string
+	Id        string
+}
+
+// CommentQuery selects a page of a thread: the oldest comments, or those right after a cursor.
+type CommentQuery struct {
+	After *CommentCursor
+	Limit int
+}
+
+// CommentPage is a window of a thread in chronological order, telling whether there are newer comments beyond it.
+type CommentPage struct {
+	Comments []Comment
+	HasMore  bool
+}
+
+// Receipt tells when a recipient acknowledged the delivery of a message and when they read it. Both are empty until
+// they do.
+type Receipt struct {
+	UserId      string `json:"userId"`
+	UserName    string `json:"userName"`
+	DeliveredAt string `json:"deliveredAt,omitempty"`
+	ReadAt      string `json:"readAt,omitempty"`
+}
+
+type Session struct {
+	Id        string `json:"id"`
+	UserId    string `json:"userId"`
+	TokenHash string `json:"-"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
 }
 
 type ReadReceipt struct {
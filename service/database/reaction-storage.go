@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AddReaction records the emoji reaction of the user to a message of the conversation. Reacting twice with the same
+// emoji changes nothing.
+func (db *appdbimpl) AddReaction(conversationID, messageID, userID, emoji string) error {
+	if err := db.checkMessageLive(conversationID, messageID); err != nil {
+		return err
+	}
+	_, err := db.c.Exec(`
+		INSERT OR IGNORE INTO reactions (messageId, userId, emoji, createdAt)
+		VALUES (?, ?, ?, ?)
+	`, messageID, userID, emoji, time.Now().UTC().Format(MessageTimestampFormat))
+	if err != nil {
+		return fmt.Errorf("error saving reaction: %w", err)
+	}
+	return nil
+}
+
+// RemoveReaction takes back the emoji reaction of the user to a message of the conversation.
+func (db *appdbimpl) RemoveReaction(conversationID, messageID, userID, emoji string) error {
+	if err := db.checkMessageLive(conversationID, messageID); err != nil {
+		return err
+	}
+	_, err := db.c.Exec(`
+		DELETE FROM reactions
+		WHERE messageId = ? AND userId = ? AND emoji = ?
+	`, messageID, userID, emoji)
+	if err != nil {
+		return fmt.Errorf("error deleting reaction: %w", err)
+	}
+	return nil
+}
+
+// checkMessageLive returns ErrMessageDoesNotExist if the message is not in the conversation, and ErrMessageDeleted if
+// it was deleted for everyone.
+func (db *appdbimpl) checkMessageLive(conversationID, messageID string) error {
+	var deletedAt sql.NullString
+	err := db.c.QueryRow(`
+		SELECT deletedAt FROM messages WHERE conversationId = ? AND id = ?
+	`, conversationID, messageID).Scan(&deletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMessageDoesNotExist
+	} else if err != nil {
+		return fmt.Errorf("error fetching message: %w", err)
+	}
+	if deletedAt.Valid {
+		return ErrMessageDeleted
+	}
+	return nil
+}
+
+// loadReactions returns the reactions to the given messages by message ID, one entry per emoji in the order the
+// emoji were first used. Messages without reactions are left out of the map.
+func (db *appdbimpl) loadReactions(messageIDs []string) (map[string][]Reaction, error) {
+	reactions := make(map[string][]Reaction)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(messageIDs)), ", ")
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := db.c.Query(`
+		SELECT r.messageId, r.emoji, r.userId, u.name
+		FROM reactions r
+		JOIN users u ON u.id = r.userId
+		WHERE r.messageId IN (`+placeholders+`)
+		ORDER BY r.messageId, r.createdAt, r.userId
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching reactions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageID, emoji, userID, userName string
+		if err := rows.Scan(&messageID, &emoji, &userID, &userName); err != nil {
+			return nil, fmt.Errorf("error scanning reaction: %w", err)
+		}
+		list := reactions[messageID]
+		i := 0
+		for i < len(list) && list[i].Emoji != emoji {
+			i++
+		}
+		if i == len(list) {
+			list = append(list, Reaction{Emoji: emoji, UserIds: []string{}, UserNames: []string{}})
+		}
+		list[i].Count++
+		list[i].UserIds = append(list[i].UserIds, userID)
+		list[i].UserNames = append(list[i].UserNames, userName)
+		reactions[messageID] = list
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reactions: %w", err)
+	}
+	return reactions, nil
+}
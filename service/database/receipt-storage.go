@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// InsertReceipt records that a message was sent to a recipient, who has neither acknowledged its delivery nor read
+// it yet.
+func (db *appdbimpl) InsertReceipt(messageID, userID string) error {
+	_, err := db.c.Exec(`
+		INSERT INTO read_receipts (messageId, userId)
+		VALUES (?, ?)
+	`, messageID, userID)
+	if err != nil {
+		return fmt.Errorf("error inserting receipt: %w", err)
+	}
+	return nil
+}
+
+// MarkMessagesDelivered records that the messages of the conversation up to and including the given one reached the
+// user, and reports whether any was not acknowledged yet.
+func (db *appdbimpl) MarkMessagesDelivered(conversationID, userID, messageID string) (bool, error) {
+	cursor, err := db.messageCursor(conversationID, messageID)
+	if err != nil {
+		return false, err
+	}
+	res, err := db.c.Exec(`
+		UPDATE read_receipts
+		SET deliveredAt = ?
+		WHERE userId = ?
+		  AND deliveredAt IS NULL
+		  AND messageId IN (SELECT id FROM messages WHERE conversationId = ? AND (timestamp, id) <= (?, ?))
+	`, time.Now().UTC().Format(MessageTimestampFormat), userID, conversationID, cursor.Timestamp, cursor.Id)
+	if err != nil {
+		return false, fmt.Errorf("error marking messages delivered: %w", err)
+	}
+	delivered, err := res.RowsAffected()
+	return delivered > 0, err
+}
+
+// MarkMessagesRead records that the user read the messages of the conversation up to and including the given one,
+// and reports whether any was unread. Reading a message also acknowledges its delivery, and moves the read marker of
+// the user forward, never back.
+func (db *appdbimpl) MarkMessagesRead(conversationID, userID, messageID string) (bool, error) {
+	cursor, err := db.messageCursor(conversationID, messageID)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC().Format(MessageTimestampFormat)
+	var read, moved int64
+	// The receipts and the read marker, which unread counts rely on, move together.
+	err = db.withTx(func(tx *appdbimpl) error {
+		res, err := tx.c.Exec(`
+			UPDATE read_receipts
+			SET readAt = ?, deliveredAt = IFNULL(deliveredAt, ?)
+			WHERE userId = ?
+			  AND readAt IS NULL
+			  AND messageId IN (SELECT id FROM messages WHERE conversationId = ? AND (timestamp, id) <= (?, ?))
+		`, now, now, userID, conversationID, cursor.Timestamp, cursor.Id)
+		if err != nil {
+			return fmt.Errorf("error marking messages read: %w", err)
+		}
+		if read, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		res, err = tx.c.Exec(`
+			UPDATE conversation_members
+			SET lastReadMessageId = ?, lastReadTimestamp = ?
+			WHERE conversationId = ? AND userId = ?
+			  AND (lastReadTimestamp IS NULL OR (lastReadTimestamp, lastReadMessageId) < (?, ?))
+		`, cursor.Id, cursor.Timestamp, conversationID, userID, cursor.Timestamp, cursor.Id)
+		if err != nil {
+			return fmt.Errorf("error moving read marker: %w", err)
+		}
+		moved, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return read > 0 || moved > 0, nil
+}
+
+// unreadCounts selects, for the user given as parameter, the number of messages they have not read in each of their
+// conversations: the messages of other members after their read marker, leaving out system messages, messages
+// deleted for everyone and the ones they hid.
+const unreadCounts = `
+	SELECT m.conversationId, COUNT(*) AS unread
+	FROM conversation_members me
+	JOIN messages m ON m.conversationId = me.conversationId
+	WHERE me.userId = ?
+		AND m.senderId != me.userId
+		AND m.kind = '` + MessageKindUser + `'
+		AND m.deletedAt IS NULL
+		AND (me.lastReadTimestamp IS NULL OR (m.timestamp, m.id) > (me.lastReadTimestamp, me.lastReadMessageId))
+		AND m.id NOT IN (SELECT messageId FROM hidden_messages WHERE userId = me.userId)
+	GROUP BY m.conversationId`
+
+// GetUnreadCount returns how many messages the user has not read across their conversations.
+func (db *appdbimpl) GetUnreadCount(userID string) (UnreadCount, error) {
+	var count UnreadCount
+	err := db.c.QueryRow(`
+		SELECT IFNULL(SUM(unread), 0), COUNT(*) FROM (`+unreadCounts+`)
+	`, userID).Scan(&count.Messages, &count.Conversations)
+	if err != nil {
+		return UnreadCount{}, fmt.Errorf("error counting unread messages: %w", err)
+	}
+	return count, nil
+}
+
+// messageCursor returns the position of a message of the conversation.
+func (db *appdbimpl) messageCursor(conversationID, messageID string) (MessageCursor, error) {
+	cursor := MessageCursor{Id: messageID}
+	err := db.c.QueryRow(`
+		SELECT timestamp FROM messages WHERE conversationId = ? AND id = ?
+	`, conversationID, messageID).Scan(&cursor.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MessageCursor{}, ErrMessageDoesNotExist
+	} else if err != nil {
+		return MessageCursor{}, fmt.Errorf("error fetching message: %w", err)
+	}
+	return cursor, nil
+}
+
+// GetMessageReceipts returns, for each recipient of a message the user sent, when they acknowledged its delivery and
+// when they read it, ordered by name.
+func (db *appdbimpl) GetMessageReceipts(conversationID, messageID, userID string) ([]Receipt, error) {
+	var senderID string
+	err := db.c.QueryRow(`
+		SELECT senderId FROM messages WHERE conversationId = ? AND id = ?
+	`, conversationID, messageID).Scan(&senderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageDoesNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("error fetching message: %w", err)
+	}
+	if senderID != userID {
+		return nil, ErrUnauthorizedToViewReceipts
+	}
+	rows, err := db.c.Query(`
+		SELECT rr.userId, u.name, IFNULL(rr.deliveredAt, ''), IFNULL(rr.readAt, '')
+		FROM read_receipts rr
+		JOIN users u ON u.id = rr.userId
+		WHERE rr.messageId = ?
+		ORDER BY u.name
+	`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching receipts: %w", err)
+	}
+	defer rows.Close()
+	receipts := []Receipt{}
+	for rows.Next() {
+		var rc Receipt
+		if err := rows.Scan(&rc.UserId, &rc.UserName, &rc.DeliveredAt, &rc.ReadAt); err != nil {
+			return nil, fmt.Errorf("error scanning receipt: %w", err)
+		}
+		receipts = append(receipts, rc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating receipts: %w", err)
+	}
+	return receipts, nil
+}
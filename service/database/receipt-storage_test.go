@@ -0,0 +1,315 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// sendTestMessage saves a message with a receipt for every other member, as sending one does.
+func sendTestMessage(t *testing.T, db *appdbimpl, conversationID, senderID, messageID, content string) {
+	t.Helper()
+	members, err := db.GetConversationMembers(conversationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SaveMessage(conversationID, senderID, messageID, content, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, memberID := range members {
+		if memberID != senderID {
+			if err := db.InsertReceipt(messageID, memberID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// newTestGroup returns a database with a group "g" of alice, bob and carol, where alice sent m1 then m2.
+func newTestGroup(t *testing.T) *appdbimpl {
+	t.Helper()
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice", "bob", "carol")
+	if err := db.CreateGroupConversation("g", "alice", []string{"alice", "bob", "carol"}, "group", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	sendTestMessage(t, db, "g", "alice", "m1", "first")
+	sendTestMessage(t, db, "g", "alice", "m2", "second")
+	return db
+}
+
+// messageStatuses returns the status of each message of the conversation, as loaded for the user.
+func messageStatuses(t *testing.T, db *appdbimpl, conversationID, userID string) map[string]string {
+	t.Helper()
+	page, err := db.GetMessages(conversationID, MessageQuery{Limit: 50, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]string, len(page.Messages))
+	for _, msg := range page.Messages {
+		statuses[msg.Id] = msg.Status
+	}
+	return statuses
+}
+
+func TestMessageStatusAcrossMembers(t *testing.T) {
+	db := newTestGroup(t)
+	steps := []struct {
+		name   string
+		mark   func(conversationID, userID, messageID string) (bool, error)
+		userID string
+		upTo   string
+		// wantChanged tells whether the step acknowledged anything.
+		wantChanged bool
+		want        map[string]string
+	}{
+		{"bob receives the first", db.MarkMessagesDelivered, "bob", "m1", true,
+			map[string]string{"m1": MessageStatusSent, "m2": MessageStatusSent}},
+		{"carol receives both", db.MarkMessagesDelivered, "carol", "m2", true,
+			map[string]string{"m1": MessageStatusDelivered, "m2": MessageStatusSent}},
+		{"carol receives both again", db.MarkMessagesDelivered, "carol", "m2", false,
+			map[string]string{"m1": MessageStatusDelivered, "m2": MessageStatusSent}},
+		{"bob reads both", db.MarkMessagesRead, "bob", "m2", true,
+			map[string]string{"m1": MessageStatusDelivered, "m2": MessageStatusDelivered}},
+		{"carol reads the first", db.MarkMessagesRead, "carol", "m1", true,
+			map[string]string{"m1": MessageStatusRead, "m2": MessageStatusDelivered}},
+		{"carol reads both", db.MarkMessagesRead, "carol", "m2", true,
+			map[string]string{"m1": MessageStatusRead, "m2": MessageStatusRead}},
+		{"carol reads both again", db.MarkMessagesRead, "carol", "m2", false,
+			map[string]string{"m1": MessageStatusRead, "m2": MessageStatusRead}},
+	}
+	for _, step := range steps {
+		changed, err := step.mark("g", step.userID, step.upTo)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if changed != step.wantChanged {
+			t.Errorf("%s: got changed %t, want %t", step.name, changed, step.wantChanged)
+		}
+		// Every member sees the same status.
+		for _, userID := range []string{"alice", "bob", "carol"} {
+			statuses := messageStatuses(t, db, "g", userID)
+			for id, want := range step.want {
+				if statuses[id] != want {
+					t.Errorf("%s: %s sees %s as %s, want %s", step.name, userID, id, statuses[id], want)
+				}
+			}
+		}
+	}
+}
+
+func TestMarkMessagesReadAcknowledgesDelivery(t *testing.T) {
+	db := newTestGroup(t)
+	if _, err := db.MarkMessagesRead("g", "bob", "m2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "read_receipts", "userId = 'bob' AND deliveredAt IS NOT NULL AND readAt IS NOT NULL"); n != 2 {
+		t.Errorf("got %d messages delivered and read by bob, want 2", n)
+	}
+	// Nothing is left to deliver.
+	if changed, err := db.MarkMessagesDelivered("g", "bob", "m2"); err != nil || changed {
+		t.Errorf("got changed %t and error %v after reading", changed, err)
+	}
+}
+
+func TestMarkMessagesReadNeverMovesBack(t *testing.T) {
+	db := newTestGroup(t)
+	if _, err := db.MarkMessagesRead("g", "bob", "m2"); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := db.MarkMessagesRead("g", "bob", "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("reading an earlier message changed something")
+	}
+	if n := countRows(t, db, "conversation_members", "conversationId = 'g' AND userId = 'bob' AND lastReadMessageId = 'm2'"); n != 1 {
+		t.Error("the read marker moved back")
+	}
+}
+
+func TestMarkMessagesUnknownMessage(t *testing.T) {
+	db := newTestGroup(t)
+	createTestUsers(t, db, "dave")
+	if err := db.CreateDirectConversation("ad", "alice", "dave"); err != nil {
+		t.Fatal(err)
+	}
+	sendTestMessage(t, db, "ad", "alice", "other", "elsewhere")
+	for _, messageID := range []string{"missing", "other"} {
+		if _, err := db.MarkMessagesDelivered("g", "bob", messageID); !errors.Is(err, ErrMessageDoesNotExist) {
+			t.Errorf("delivering %s: got error %v, want %v", messageID, err, ErrMessageDoesNotExist)
+		}
+		if _, err := db.MarkMessagesRead("g", "bob", messageID); !errors.Is(err, ErrMessageDoesNotExist) {
+			t.Errorf("reading %s: got error %v, want %v", messageID, err, ErrMessageDoesNotExist)
+		}
+	}
+}
+
+func TestMarkMessagesReadRollsBack(t *testing.T) {
+	db := newTestGroup(t)
+	// The receipts are updated first, then moving the read marker fails.
+	_, err := db.c.Exec(`
+		CREATE TRIGGER fail_read_marker BEFORE UPDATE OF lastReadMessageId ON conversation_members
+		BEGIN SELECT RAISE(ABORT, 'injected failure'); END
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MarkMessagesRead("g", "bob", "m2"); err == nil {
+		t.Fatal("marking the messages read succeeded")
+	}
+	if n := countRows(t, db, "read_receipts", "readAt IS NOT NULL OR deliveredAt IS NOT NULL"); n != 0 {
+		t.Errorf("%d receipts were kept", n)
+	}
+	if n := countRows(t, db, "conversation_members", "lastReadMessageId IS NOT NULL"); n != 0 {
+		t.Errorf("%d read markers moved", n)
+	}
+}
+
+func TestGetMessageReceipts(t *testing.T) {
+	db := newTestGroup(t)
+	if _, err := db.MarkMessagesRead("g", "carol", "m1"); err != nil {
+		t.Fatal(err)
+	}
+	receipts, err := db.GetMessageReceipts("g", "m1", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 || receipts[0].UserId != "bob" || receipts[1].UserId != "carol" {
+		t.Fatalf("got receipts %+v, want bob's then carol's", receipts)
+	}
+	if receipts[0].DeliveredAt != "" || receipts[0].ReadAt != "" {
+		t.Errorf("bob's receipt is %+v, want neither delivered nor read", receipts[0])
+	}
+	if receipts[1].DeliveredAt == "" || receipts[1].ReadAt == "" {
+		t.Errorf("carol's receipt is %+v, want delivered and read", receipts[1])
+	}
+
+	tests := []struct {
+		name                              string
+		conversationID, messageID, userID string
+		wantErr                           error
+	}{
+		{"recipient", "g", "m1", "bob", ErrUnauthorizedToViewReceipts},
+		{"recipient who read it", "g", "m1", "carol", ErrUnauthorizedToViewReceipts},
+		{"non-member", "g", "m1", "nobody", ErrUnauthorizedToViewReceipts},
+		{"missing message", "g", "missing", "alice", ErrMessageDoesNotExist},
+		{"other conversation", "other", "m1", "alice", ErrMessageDoesNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipts, err := db.GetMessageReceipts(tt.conversationID, tt.messageID, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if receipts != nil {
+				t.Errorf("got receipts %+v", receipts)
+			}
+		})
+	}
+}
+
+// unreadCount returns the unread count of the user, checking that the count of each of their conversations adds up
+// to it.
+func unreadCount(t *testing.T, db *appdbimpl, userID string) UnreadCount {
+	t.Helper()
+	count, err := db.GetUnreadCount(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversations, err := db.GetMyConversations(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum UnreadCount
+	for _, conv := range conversations {
+		if conv.UnreadCount > 0 {
+			sum.Messages += conv.UnreadCount
+			sum.Conversations++
+		}
+	}
+	if sum != count {
+		t.Errorf("%s: the conversations add up to %+v, the total is %+v", userID, sum, count)
+	}
+	return count
+}
+
+func TestUnreadCount(t *testing.T) {
+	db := newTestGroup(t)
+	if err := db.CreateDirectConversation("ab", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	sendTestMessage(t, db, "ab", "alice", "d1", "direct")
+	steps := []struct {
+		name   string
+		action func() error
+		want   map[string]UnreadCount
+	}{
+		{"sent", func() error { return nil },
+			map[string]UnreadCount{"alice": {}, "bob": {3, 2}, "carol": {2, 1}}},
+		{"bob reads the first of the group", func() error { _, err := db.MarkMessagesRead("g", "bob", "m1"); return err },
+			map[string]UnreadCount{"bob": {2, 2}, "carol": {2, 1}}},
+		{"carol receives the group", func() error { _, err := db.MarkMessagesDelivered("g", "carol", "m2"); return err },
+			map[string]UnreadCount{"carol": {2, 1}}},
+		{"carol hides the first", func() error { return db.HideMessage("g", "m1", "carol") },
+			map[string]UnreadCount{"bob": {2, 2}, "carol": {1, 1}}},
+		{"alice deletes the second", func() error { return db.DeleteMessage("g", "m2", "alice") },
+			map[string]UnreadCount{"bob": {1, 1}, "carol": {}}},
+		{"a system message", func() error {
+			_, err := db.SaveSystemMessage("g", "s1", "", SystemEvent{Type: "renamed", ActorId: "alice", Value: "group"})
+			return err
+		}, map[string]UnreadCount{"bob": {1, 1}, "carol": {}}},
+		{"bob reads the direct message", func() error { _, err := db.MarkMessagesRead("ab", "bob", "d1"); return err },
+			map[string]UnreadCount{"alice": {}, "bob": {}, "carol": {}}},
+	}
+	for _, step := range steps {
+		if err := step.action(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		for userID, want := range step.want {
+			if got := unreadCount(t, db, userID); got != want {
+				t.Errorf("%s: %s has %+v unread, want %+v", step.name, userID, got, want)
+			}
+		}
+	}
+}
+
+func TestUnreadCountAfterJoining(t *testing.T) {
+	db := newTestGroup(t)
+	createTestUsers(t, db, "dave")
+	// The messages before the join are stamped no earlier than it, as within the same millisecond, or by a clock
+	// running ahead.
+	restamp := func(condition string, ahead time.Duration) {
+		t.Helper()
+		timestamp := time.Now().Add(ahead).UTC().Format(MessageTimestampFormat)
+		if _, err := db.c.Exec(`UPDATE messages SET timestamp = ? WHERE `+condition, timestamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restamp("1", time.Second)
+	if err := db.AddUserToGroup("g", "dave"); err != nil {
+		t.Fatal(err)
+	}
+	if got := unreadCount(t, db, "dave"); got != (UnreadCount{}) {
+		t.Errorf("the messages sent before joining count as %+v unread", got)
+	}
+	sendTestMessage(t, db, "g", "bob", "m3", "welcome")
+	restamp("id = 'm3'", 2*time.Second)
+	if got, want := unreadCount(t, db, "dave"), (UnreadCount{1, 1}); got != want {
+		t.Errorf("got %+v unread after joining, want %+v", got, want)
+	}
+	// The messages before joining are still there to read, and reading them moves the marker to where it was.
+	if _, err := db.MarkMessagesRead("g", "dave", "m2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := unreadCount(t, db, "dave"), (UnreadCount{1, 1}); got != want {
+		t.Errorf("got %+v unread after reading an earlier message, want %+v", got, want)
+	}
+	if _, err := db.MarkMessagesRead("g", "dave", "m3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := unreadCount(t, db, "dave"); got != (UnreadCount{}) {
+		t.Errorf("got %+v unread after reading everything", got)
+	}
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Markers delimiting the matched terms in raw snippets, before they are turned into HTML. They are control characters,
+// so that they do not clash with ordinary text.
+const (
+	snippetMatchStart = "\x01"
+	snippetMatchEnd   = "\x02"
+)
+
+// likeSnippetContext is how many characters the snippets built without the full-text index show before the first
+// match, and after it.
+const likeSnippetContext = 40
+
+// setupMessageSearch adapts the full-text index of message content to how SQLite was built, and reports whether
+// searches can use it. The index needs SQLite built with FTS5 (the sqlite_fts5 build tag). Without it, the triggers
+// maintaining the index are dropped so that writes keep working, and searches fall back to LIKE. With it, an index
+// that lost its triggers, or was never created because the database was migrated by a build without FTS5, is created
+// again by the message search migration, since messages written meanwhile are not in it.
+func setupMessageSearch(db *sql.DB) (bool, error) {
+	available, err := fts5Available(db)
+	if err != nil {
+		return false, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	var triggers int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages\_fts\_%' ESCAPE '\'
+	`).Scan(&triggers)
+	if err != nil {
+		return false, fmt.Errorf("error checking full-text index triggers: %w", err)
+	}
+	switch {
+	case !available && triggers > 0:
+		err = dropMessageSearchTriggers(tx)
+	case available && triggers != len(messageSearchTriggers):
+		err = addMessageSearch(tx)
+	default:
+		return available, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error setting up full-text index: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return available, nil
+}
+
+// fts5Available tells whether SQLite was built with FTS5.
+func fts5Available(db dbConn) (bool, error) {
+	var available bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available); err != nil {
+		return false, fmt.Errorf("error checking full-text search support: %w", err)
+	}
+	return available, nil
+}
+
+// SearchMessages returns a page of the messages of the user's conversations containing all the search terms, newest
+// first. System messages, messages deleted for everyone and the ones the user hid are left out.
+func (db *appdbimpl) SearchMessages(query MessageSearch) (MessageSearchPage, error) {
+	if len(query.Terms) == 0 {
+		return MessageSearchPage{Matches: []MessageMatch{}}, nil
+	}
+	snippet := `m.content`
+	from := `messages m`
+	var conditions []string
+	var args []interface{}
+	if db.fts {
+		snippet = `snippet(messages_fts, 0, '` + snippetMatchStart + `', '` + snippetMatchEnd + `', '…', 12)`
+		from = `messages_fts f JOIN messages m ON m.rowid = f.rowid`
+		conditions = append(conditions, `messages_fts MATCH ?`)
+		args = append(args, ftsMatchExpression(query.Terms))
+	} else {
+		for _, term := range query.Terms {
+			conditions = append(conditions, `m.content LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+	}
+	conditions = append(conditions,
+		`m.kind = '`+MessageKindUser+`'`,
+		`m.deletedAt IS NULL`,
+		`m.id NOT IN (SELECT messageId FROM hidden_messages WHERE userId = ?)`)
+	args = append(args, query.UserID)
+	if query.ConversationID != "" {
+		conditions = append(conditions, `m.conversationId = ?`)
+		args = append(args, query.ConversationID)
+	}
+	if query.From != "" {
+		conditions = append(conditions, `m.timestamp >= ?`)
+		args = append(args, query.From)
+	}
+	if query.Until != "" {
+		conditions = append(conditions, `m.timestamp < ?`)
+		args = append(args, query.Until)
+	}
+	if query.Before != nil {
+		conditions = append(conditions, `(m.timestamp, m.id) < (?, ?)`)
+		args = append(args, query.Before.Timestamp, query.Before.Id)
+	}
+	// One more match than asked for tells whether there are older ones.
+	rows, err := db.c.Query(`
+		SELECT
+			m.id,
+			m.conversationId,
+			c.type,
+			CASE
+				WHEN c.type = 'direct' THEN
+					(SELECT ou.name
+					FROM users ou
+					JOIN conversation_members ocm ON ou.id = ocm.userId
+					WHERE ocm.conversationId = c.id AND ou.id != cm.userId)
+				ELSE c.name
+			END,
+			m.senderId,
+			u.name,
+			m.timestamp,
+			`+snippet+`
+		FROM `+from+`
+		JOIN conversation_members cm ON cm.conversationId = m.conversationId AND cm.userId = ?
+		JOIN conversations c ON c.id = m.conversationId
+		JOIN users u ON u.id = m.senderId
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY m.timestamp DESC, m.id DESC
+		LIMIT ?
+	`, append(append([]interface{}{query.UserID}, args...), query.Limit+1)...)
+	if err != nil {
+		return MessageSearchPage{}, fmt.Errorf("error searching messages: %w", err)
+	}
+	defer rows.Close()
+	page := MessageSearchPage{Matches: []MessageMatch{}}
+	for rows.Next() {
+		var m MessageMatch
+		var conversationName sql.NullString
+		var raw string
+		err := rows.Scan(&m.MessageId, &m.ConversationId, &m.ConversationType, &conversationName, &m.SenderId,
+			&m.SenderName, &m.Timestamp, &raw)
+		if err != nil {
+			return MessageSearchPage{}, fmt.Errorf("error scanning search result: %w", err)
+		}
+		m.ConversationName = conversationName.String
+		if !db.fts {
+			raw = likeSnippet(raw, query.Terms)
+		}
+		m.Snippet = snippetHTML(raw)
+		page.Matches = append(page.Matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return MessageSearchPage{}, fmt.Errorf("error iterating search results: %w", err)
+	}
+	if len(page.Matches) > query.Limit {
+		page.Matches = page.Matches[:query.Limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// ftsMatchExpression returns the FTS5 query matching content with all the terms, each as a prefix. Terms are quoted,
+// so that the FTS5 query syntax in them is taken literally.
+func ftsMatchExpression(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
+// likeSnippet returns the part of the content around the first match of a term, with the matches marked as the FTS5
+// snippet function does.
+func likeSnippet(content string, terms []string) string {
+	text := []rune(content)
+	// Lowering maps rune to rune, so indexes in the lowered text are indexes in the content.
+	lower := []rune(strings.ToLower(content))
+	var matches [][2]int
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) == string(t) {
+				matches = append(matches, [2]int{i, i + len(t)})
+				i += len(t) - 1
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return content
+	}
+	first := matches[0][0]
+	for _, m := range matches {
+		if m[0] < first {
+			first = m[0]
+		}
+	}
+	start, end := first-likeSnippetContext, first+2*likeSnippetContext
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	marked := make([]bool, len(text))
+	for _, m := range matches {
+		for i := m[0]; i < m[1]; i++ {
+			marked[i] = true
+		}
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if marked[i] && (i == start || !marked[i-1]) {
+			b.WriteString(snippetMatchStart)
+		}
+		b.WriteRune(text[i])
+		if marked[i] && (i == end-1 || !marked[i+1]) {
+			b.WriteString(snippetMatchEnd)
+		}
+	}
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// snippetHTML escapes a raw snippet for HTML and turns its match markers into mark elements.
+func snippetHTML(raw string) string {
+	escaped := html.EscapeString(raw)
+	return strings.NewReplacer(snippetMatchStart, "<mark>", snippetMatchEnd, "</mark>").Replace(escaped)
+}
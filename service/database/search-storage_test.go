@@ -0,0 +1,300 @@
+package database
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests of this file run against the full-text index when built with the sqlite_fts5 tag, and against the LIKE
+// fallback otherwise.
+
+// searchIDs returns the IDs of the messages the search finds, in the order they are returned.
+func searchIDs(t *testing.T, db *appdbimpl, query MessageSearch) []string {
+	t.Helper()
+	if query.Limit == 0 {
+		query.Limit = 50
+	}
+	page, err := db.SearchMessages(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, m := range page.Matches {
+		ids = append(ids, m.MessageId)
+	}
+	return ids
+}
+
+func TestMessageSearchIndex(t *testing.T) {
+	db := newTestDatabase(t)
+	available, err := fts5Available(db.c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.fts != available {
+		t.Fatalf("searching through the index is %t, FTS5 support is %t", db.fts, available)
+	}
+	wantTriggers := 0
+	if available {
+		wantTriggers = len(messageSearchTriggers)
+	}
+	if n := countRows(t, db, "sqlite_master", "type = 'trigger' AND name LIKE 'messages_fts_%'"); n != wantTriggers {
+		t.Errorf("got %d index triggers, want %d", n, wantTriggers)
+	}
+	if !available {
+		t.Skip("SQLite is built without FTS5")
+	}
+
+	// An index that lost its triggers is created again on open, with the messages written meanwhile.
+	createTestUsers(t, db, "alice", "bob")
+	if err := db.CreateDirectConversation("ab", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dropMessageSearchTriggers(tx); err != nil {
+		_ = tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	sendTestMessage(t, db, "ab", "alice", "m1", "unindexed words")
+	reopened, err := New(db.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := searchIDs(t, reopened.(*appdbimpl), MessageSearch{UserID: "bob", Terms: []string{"unindexed"}}); !reflect.DeepEqual(got, []string{"m1"}) {
+		t.Errorf("got %v after reopening, want [m1]", got)
+	}
+}
+
+func TestMessageSearchMigration(t *testing.T) {
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice", "bob")
+	if err := db.CreateDirectConversation("ab", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	latest := LatestSchemaVersion()
+	if err := MigrateTo(db.conn, latest-1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "sqlite_master", "name LIKE 'messages_fts%'"); n != 0 {
+		t.Errorf("%d parts of the index are left after rolling back", n)
+	}
+	// Messages written without the index are indexed when it is created.
+	sendTestMessage(t, db, "ab", "alice", "m1", "written before the index")
+	if err := MigrateTo(db.conn, latest); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchIDs(t, db, MessageSearch{UserID: "bob", Terms: []string{"before"}}); !reflect.DeepEqual(got, []string{"m1"}) {
+		t.Errorf("got %v, want [m1]", got)
+	}
+}
+
+// newSearchDatabase returns a database where alice, bob and carol are in the group g, alice and bob in the direct
+// conversation ab, and carol and dave in cd. Each conversation has a message saying hello.
+func newSearchDatabase(t *testing.T) *appdbimpl {
+	t.Helper()
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice", "bob", "carol", "dave")
+	if err := db.CreateGroupConversation("g", "alice", []string{"alice", "bob", "carol"}, "group", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, members := range [][2]string{{"alice", "bob"}, {"carol", "dave"}} {
+		conversationID := members[0][:1] + members[1][:1]
+		if err := db.CreateDirectConversation(conversationID, members[0], members[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sendTestMessage(t, db, "g", "alice", "g1", "hello team")
+	sendTestMessage(t, db, "ab", "bob", "ab1", "hello alice")
+	sendTestMessage(t, db, "cd", "dave", "cd1", "hello carol, a secret")
+	return db
+}
+
+func TestSearchMessagesScope(t *testing.T) {
+	db := newSearchDatabase(t)
+	tests := []struct {
+		name           string
+		userID         string
+		conversationID string
+		want           []string
+	}{
+		{"alice", "alice", "", []string{"ab1", "g1"}},
+		{"carol", "carol", "", []string{"cd1", "g1"}},
+		{"dave", "dave", "", []string{"cd1"}},
+		{"in the group", "alice", "g", []string{"g1"}},
+		{"in a conversation of others", "alice", "cd", []string{}},
+		{"not a user", "nobody", "", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchIDs(t, db, MessageSearch{UserID: tt.userID, ConversationID: tt.conversationID, Terms: []string{"hello"}})
+			if !reflect.DeepEqual(sorted(got), tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Leaving the group takes its messages out of the results.
+	if _, err := db.LeaveGroup("g", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchIDs(t, db, MessageSearch{UserID: "bob", Terms: []string{"hello"}}); !reflect.DeepEqual(got, []string{"ab1"}) {
+		t.Errorf("got %v after leaving the group, want [ab1]", got)
+	}
+}
+
+// sorted returns the IDs in ascending order, for results whose order depends on the time they were written.
+func sorted(ids []string) []string {
+	out := append([]string{}, ids...)
+	sort.Strings(out)
+	return out
+}
+
+func TestSearchMessagesTerms(t *testing.T) {
+	db := newSearchDatabase(t)
+	sendTestMessage(t, db, "g", "bob", "g2", "Hello again, TEAM")
+	tests := []struct {
+		name  string
+		terms []string
+		want  []string
+	}{
+		{"any case", []string{"HELLO"}, []string{"g1", "g2"}},
+		{"all the terms", []string{"hello", "again"}, []string{"g2"}},
+		{"a term missing", []string{"hello", "nowhere"}, []string{}},
+		{"no terms", nil, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchIDs(t, db, MessageSearch{UserID: "alice", ConversationID: "g", Terms: tt.terms})
+			if !reflect.DeepEqual(sorted(got), tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMessagesDates(t *testing.T) {
+	db := newSearchDatabase(t)
+	for _, day := range []string{"01", "02", "03"} {
+		id := "d" + day
+		sendTestMessage(t, db, "g", "alice", id, "daily report")
+		if _, err := db.c.Exec(`UPDATE messages SET timestamp = ? WHERE id = ?`, "2025-01-"+day+"T09:00:00.000Z", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name        string
+		from, until string
+		want        []string
+	}{
+		{"no bounds", "", "", []string{"d03", "d02", "d01"}},
+		{"from a message, inclusive", "2025-01-02T09:00:00.000Z", "", []string{"d03", "d02"}},
+		{"until a message, exclusive", "", "2025-01-02T09:00:00.000Z", []string{"d01"}},
+		{"one day", "2025-01-02T00:00:00.000Z", "2025-01-03T00:00:00.000Z", []string{"d02"}},
+		{"after all", "2025-01-04T00:00:00.000Z", "", []string{}},
+		{"empty range", "2025-01-03T00:00:00.000Z", "2025-01-02T00:00:00.000Z", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchIDs(t, db, MessageSearch{UserID: "bob", Terms: []string{"report"}, From: tt.from, Until: tt.until})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Pages go on before the last match of the previous one.
+	page, err := db.SearchMessages(MessageSearch{UserID: "bob", Terms: []string{"report"}, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Matches) != 2 || !page.HasMore {
+		t.Fatalf("got %d matches and more %t, want 2 and more", len(page.Matches), page.HasMore)
+	}
+	last := page.Matches[1]
+	got := searchIDs(t, db, MessageSearch{UserID: "bob", Terms: []string{"report"},
+		Before: &MessageCursor{Timestamp: last.Timestamp, Id: last.MessageId}})
+	if !reflect.DeepEqual(got, []string{"d01"}) {
+		t.Errorf("got %v on the second page, want [d01]", got)
+	}
+}
+
+func TestSearchMessagesSnippet(t *testing.T) {
+	db := newSearchDatabase(t)
+	sendTestMessage(t, db, "g", "alice", "html", `<b>bold</b> & "hello" it's`)
+	page, err := db.SearchMessages(MessageSearch{UserID: "bob", ConversationID: "g", Terms: []string{"bold", "hello"}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(page.Matches))
+	}
+	want := `&lt;b&gt;<mark>bold</mark>&lt;/b&gt; &amp; &#34;<mark>hello</mark>&#34; it&#39;s`
+	if got := page.Matches[0].Snippet; got != want {
+		t.Errorf("got snippet %s, want %s", got, want)
+	}
+
+	// Long content is cut around the match.
+	long := strings.Repeat("filler ", 40) + "needle " + strings.Repeat("filler ", 40)
+	sendTestMessage(t, db, "g", "alice", "long", long)
+	page, err = db.SearchMessages(MessageSearch{UserID: "bob", Terms: []string{"needle"}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(page.Matches))
+	}
+	snippet := page.Matches[0].Snippet
+	if !strings.Contains(snippet, "<mark>needle</mark>") || !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") {
+		t.Errorf("got snippet %s", snippet)
+	}
+}
+
+func TestSearchMessagesLeavesOut(t *testing.T) {
+	db := newSearchDatabase(t)
+	sendTestMessage(t, db, "g", "alice", "deleted", "hello from a deleted message")
+	sendTestMessage(t, db, "g", "alice", "hidden", "hello from a hidden message")
+	sendTestMessage(t, db, "g", "alice", "edited", "hello from a draft")
+	if err := db.DeleteMessage("g", "deleted", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HideMessage("g", "hidden", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.EditMessage("g", "edited", "alice", "hi from the final version", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SaveSystemMessage("g", "system", "hello from the system", SystemEvent{Type: "renamed", ActorId: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		userID string
+		terms  []string
+		want   []string
+	}{
+		{"deleted for everyone", "alice", []string{"deleted"}, []string{}},
+		{"hidden by the reader", "bob", []string{"hidden"}, []string{}},
+		{"hidden by someone else", "carol", []string{"hidden"}, []string{"hidden"}},
+		{"the content before an edit", "bob", []string{"draft"}, []string{}},
+		{"the content after an edit", "bob", []string{"final"}, []string{"edited"}},
+		{"system messages", "bob", []string{"system"}, []string{}},
+		{"all of them", "bob", []string{"hello"}, []string{"g1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchIDs(t, db, MessageSearch{UserID: tt.userID, ConversationID: "g", Terms: tt.terms})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (db *appdbimpl) CreateSession(s Session) error {
+	_, err := db.c.Exec(`
+		INSERT INTO sessions (id, userId, tokenHash, createdAt, expiresAt)
+		VALUES (?, ?, ?, ?, ?)
+	`, s.Id, s.UserId, s.TokenHash, s.CreatedAt, s.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("error creating session: %w", err)
+	}
+	return nil
+}
+
+func (db *appdbimpl) GetSessionByTokenHash(tokenHash string) (Session, error) {
+	var s Session
+	err := db.c.QueryRow(`
+		SELECT id, userId, tokenHash, createdAt, expiresAt
+		FROM sessions
+		WHERE tokenHash = ?
+	`, tokenHash).Scan(&s.Id, &s.UserId, &s.TokenHash, &s.CreatedAt, &s.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrSessionDoesNotExist
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("error fetching session: %w", err)
+	}
+	return s, nil
+}
+
+// GetUserSessions returns the sessions of the user that have not expired yet. Session times are stored in UTC in RFC
+// 3339, so they compare as strings.
+func (db *appdbimpl) GetUserSessions(userID string) ([]Session, error) {
+	rows, err := db.c.Query(`
+		SELECT id, userId, createdAt, expiresAt
+		FROM sessions
+		WHERE userId = ? AND expiresAt > ?
+		ORDER BY createdAt DESC
+	`, userID, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+	defer rows.Close()
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.Id, &s.UserId, &s.CreatedAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("error scanning session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+func (db *appdbimpl) DeleteSession(sessionID, userID string) error {
+	res, err := db.c.Exec(`DELETE FROM sessions WHERE id = ? AND userId = ?`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrSessionDoesNotExist
+	}
+	return nil
+}
+
+func (db *appdbimpl) DeleteOtherSessions(userID, keepSessionID string) error {
+	_, err := db.c.Exec(`DELETE FROM sessions WHERE userId = ? AND id != ?`, userID, keepSessionID)
+	if err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetUserSessionsSkipsExpired(t *testing.T) {
+	db := newTestDatabase(t)
+	createTestUsers(t, db, "alice", "bob")
+	now := time.Now().UTC()
+	sessions := []Session{
+		{Id: "active", UserId: "alice", TokenHash: "a", ExpiresAt: now.Add(time.Hour).Format(time.RFC3339)},
+		{Id: "expired", UserId: "alice", TokenHash: "b", ExpiresAt: now.Add(-time.Second).Format(time.RFC3339)},
+		{Id: "other", UserId: "bob", TokenHash: "c", ExpiresAt: now.Add(time.Hour).Format(time.RFC3339)},
+	}
+	for _, s := range sessions {
+		s.CreatedAt = now.Add(-2 * time.Hour).Format(time.RFC3339)
+		if err := db.CreateSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := db.GetUserSessions("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Id != "active" {
+		t.Errorf("got sessions %+v, want only the active one", got)
+	}
+}
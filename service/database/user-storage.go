@@ -6,7 +6,7 @@ import (
 )
 
 func (db *appdbimpl) CreateUser(u User) (User, error) {
-	_, err := db.c.Exec("INSERT INTO users(id, name, photo) VALUES (?, ?, ?)", u.Id, u.Name, u.Photo)
+	_, err := db.c.Exec("INSERT INTO users(id, name, photo, photoThumb) VALUES (?, ?, ?, ?)", u.Id, u.Name, u.Photo, u.PhotoThumb)
 	if err != nil {
 		var existing User
 		if errCheck := db.c.QueryRow("SELECT id, name FROM users WHERE name = ?", u.Name).Scan(&existing.Id, &existing.Name); errCheck != nil {
@@ -55,7 +55,7 @@ func (db *appdbimpl) UpdateUserName(userId, newName string) (User, error) {
 	return db.GetUserById(userId)
 }
 
-func (db *appdbimpl) UpdateUserPhoto(userID string, photo []byte) error {
+func (db *appdbimpl) UpdateUserPhoto(userID string, photo, photoThumb []byte) error {
 	var exists bool
 	err := db.c.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id=?)`, userID).Scan(&exists)
 	if err != nil {
@@ -64,7 +64,7 @@ func (db *appdbimpl) UpdateUserPhoto(userID string, photo []byte) error {
 	if !exists {
 		return ErrUserDoesNotExist
 	}
-	_, err = db.c.Exec(`UPDATE users SET photo=? WHERE id=?`, photo, userID)
+	_, err = db.c.Exec(`UPDATE users SET photo=?, photoThumb=? WHERE id=?`, photo, photoThumb, userID)
 	if err != nil {
 		return err
 	}
@@ -74,7 +74,7 @@ func (db *appdbimpl) UpdateUserPhoto(userID string, photo []byte) error {
 func (db *appdbimpl) SearchUsersByName(username string) ([]User, error) {
 	var users []User
 	rows, err := db.c.Query(`
-        SELECT id, name, photo
+        SELECT id, name, COALESCE(photoThumb, photo)
         FROM users
         WHERE name LIKE ?`,
 		"%"+username+"%")
@@ -110,3 +110,28 @@ func (db *appdbimpl) GetUsersPhoto(userID string) (User, error) {
 	}
 	return user, nil
 }
+
+func (db *appdbimpl) GetUserPasswordHash(userID string) (string, error) {
+	var passwordHash sql.NullString
+	err := db.c.QueryRow(`SELECT passwordHash FROM users WHERE id = ?`, userID).Scan(&passwordHash)
+	if err == sql.ErrNoRows {
+		return "", ErrUserDoesNotExist
+	} else if err != nil {
+		return "", fmt.Errorf("error fetching password hash: %w", err)
+	}
+	return passwordHash.String, nil
+}
+
+func (db *appdbimpl) SetUserPasswordHash(userID, passwordHash string) error {
+	res, err := db.c.Exec(`UPDATE users SET passwordHash = ? WHERE id = ?`, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("error updating password hash: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrUserDoesNotExist
+	}
+	return nil
+}
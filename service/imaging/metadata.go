@@ -0,0 +1,152 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errTruncated = errors.New("truncated image")
+
+// exifOrientationTag is the EXIF tag recording how the camera was held.
+const exifOrientationTag = 0x0112
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, or 1 (upright) when there is none. Only the segment
+// headers are read; anything malformed reads as upright.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker.
+			i++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// The image data starts: no metadata segment can follow.
+			return 1
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01:
+			// Markers without a segment.
+			i += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation from the first IFD of the TIFF structure embedded in an EXIF segment.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < entries; k++ {
+		entry := ifd + 2 + 12*k
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		// The orientation is a single SHORT (type 3), stored in the value field of the entry.
+		if order.Uint16(tiff[entry:]) == exifOrientationTag && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
+
+// gifFramePixels adds up the areas of the frames of a GIF image by walking its block structure, without decoding
+// any frame.
+func gifFramePixels(data []byte) (int, error) {
+	if len(data) < 13 {
+		return 0, errTruncated
+	}
+	i := 13
+	if packed := data[10]; packed&0x80 != 0 {
+		// Global color table.
+		i += 3 << (packed&0x07 + 1)
+	}
+	pixels := 0
+	for {
+		if i >= len(data) {
+			return 0, errTruncated
+		}
+		switch data[i] {
+		case 0x21:
+			// Extension: introducer, label and data sub-blocks.
+			next, err := skipGIFSubBlocks(data, i+2)
+			if err != nil {
+				return 0, err
+			}
+			i = next
+		case 0x2C:
+			// Image descriptor: position, size and flags, then the LZW data.
+			if i+10 > len(data) {
+				return 0, errTruncated
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int(binary.LittleEndian.Uint16(data[i+7:]))
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				// Local color table.
+				i += 3 << (packed&0x07 + 1)
+			}
+			next, err := skipGIFSubBlocks(data, i+1)
+			if err != nil {
+				return 0, err
+			}
+			i = next
+			pixels += width * height
+			if pixels > MaxPixels {
+				// No need to read further.
+				return pixels, nil
+			}
+		case 0x3B:
+			// Trailer.
+			return pixels, nil
+		default:
+			return 0, errors.New("invalid GIF block")
+		}
+	}
+}
+
+// skipGIFSubBlocks returns the offset right after the data sub-blocks starting at i.
+func skipGIFSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errTruncated
+		}
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
@@ -0,0 +1,107 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestJPEGOrientation(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	sos := []byte{0xFF, 0xDA, 0x00, 0x02}
+	jpegWith := func(parts ...[]byte) []byte {
+		data := append([]byte{}, soi...)
+		for _, part := range parts {
+			data = append(data, part...)
+		}
+		return data
+	}
+	exif := exifSegment(binary.BigEndian, 6)
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"big endian", jpegWith(exifSegment(binary.BigEndian, 6), sos), 6},
+		{"little endian", jpegWith(exifSegment(binary.LittleEndian, 8), sos), 8},
+		{"after other segments", jpegWith(jpegSegment(0xE0, []byte("JFIF\x00")), exif, sos), 6},
+		{"after fill bytes", jpegWith([]byte{0xFF, 0xFF}, exif, sos), 6},
+		{"after a restart marker", jpegWith([]byte{0xFF, 0xD0}, exif, sos), 6},
+		{"no EXIF", jpegWith(jpegSegment(0xE0, []byte("JFIF\x00")), sos), 1},
+		{"other APP1", jpegWith(jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00")), sos), 1},
+		{"EXIF after the image data", jpegWith(sos, exif), 1},
+		{"not a JPEG", append([]byte{0x89, 0x50}, exif...), 1},
+		{"empty", nil, 1},
+		{"only the start marker", soi, 1},
+		{"garbage between segments", jpegWith([]byte{0x00}, exif), 1},
+		{"segment shorter than its length", jpegWith([]byte{0xFF, 0xE1, 0x00, 0x01}, exif), 1},
+		{"segment longer than the file", jpegWith([]byte{0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f'}), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("got orientation %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTIFFOrientation(t *testing.T) {
+	// valid is the TIFF structure of an EXIF segment with orientation 6, in big endian order.
+	valid := exifSegment(binary.BigEndian, 6)[10:]
+	patched := func(offset int, values ...byte) []byte {
+		tiff := append([]byte{}, valid...)
+		copy(tiff[offset:], values)
+		return tiff
+	}
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"valid", valid, 6},
+		{"unknown byte order", patched(0, 'X', 'X'), 1},
+		{"IFD before the header end", patched(4, 0, 0, 0, 4), 1},
+		{"IFD past the end", patched(4, 0, 0, 0xFF, 0xFF), 1},
+		{"IFD offset overflowing", patched(4, 0xFF, 0xFF, 0xFF, 0xFF), 1},
+		{"more entries than there is room for", patched(8, 0x00, 0x02, 0x01, 0x10), 1},
+		{"orientation as a LONG", patched(12, 0x00, 0x04), 1},
+		{"other tag", patched(10, 0x01, 0x10), 1},
+		{"no entries", patched(8, 0x00, 0x00), 1},
+		{"header only", valid[:8], 1},
+		{"shorter than a header", valid[:7], 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tiffOrientation(tt.tiff); got != tt.want {
+				t.Errorf("got orientation %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOrientationTruncated cuts an EXIF JPEG at every length: the parsers must read what they can, never past the
+// end.
+func TestOrientationTruncated(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		data := append(append([]byte{0xFF, 0xD8}, exifSegment(order, 6)...), 0xFF, 0xDA, 0x00, 0x02)
+		for n := 0; n <= len(data); n++ {
+			got := jpegOrientation(data[:n])
+			if got != 1 && got != 6 {
+				t.Errorf("%v, %d bytes: got orientation %d", order, n, got)
+			}
+		}
+		tiff := exifSegment(order, 6)[10:]
+		for n := 0; n <= len(tiff); n++ {
+			_ = tiffOrientation(tiff[:n])
+		}
+	}
+}
+
+func FuzzJPEGOrientation(f *testing.F) {
+	f.Add(append([]byte{0xFF, 0xD8}, exifSegment(binary.BigEndian, 6)...))
+	f.Add(append([]byte{0xFF, 0xD8}, exifSegment(binary.LittleEndian, 3)...))
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x08, 'E', 'x', 'i', 'f', 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_ = jpegOrientation(data)
+	})
+}
@@ -0,0 +1,152 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// Limits on the dimensions of uploaded images, checked from the headers before anything is decoded, so that a small
+// crafted file cannot make the server allocate gigabytes. For animated GIFs, MaxPixels bounds all frames together.
+const (
+	MaxSide   = 10000
+	MaxPixels = 25000000
+)
+
+// uploadJPEGQuality is the quality of re-encoded JPEG uploads; higher than for thumbnails, since they are the
+// originals.
+const uploadJPEGQuality = 90
+
+var (
+	// ErrUnsupportedFormat is returned for files that are not JPEG, PNG or GIF images.
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrTooLarge is returned for images beyond MaxSide or MaxPixels.
+	ErrTooLarge = errors.New("image dimensions exceed the limits")
+	// ErrInvalidImage is returned for images that cannot be decoded.
+	ErrInvalidImage = errors.New("invalid image")
+)
+
+// Image is an uploaded image, decoded and encoded again without any of the metadata of the original.
+type Image struct {
+	Data     []byte
+	MimeType string
+	Width    int
+	Height   int
+
+	// decoded is the still image thumbnails are built from: the first frame for GIFs.
+	decoded image.Image
+	format  string
+}
+
+// Sanitize decodes a JPEG, PNG or GIF upload and encodes it again in the same format. Only pixels survive: EXIF
+// (GPS position included), text chunks and comments are dropped. The EXIF orientation of JPEGs is applied to the
+// pixels before it is dropped, so photos keep showing upright. GIF animations are kept.
+func Sanitize(data []byte) (*Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupportedFormat
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if format != "jpeg" && format != "png" && format != "gif" {
+		return nil, ErrUnsupportedFormat
+	}
+	if config.Width > MaxSide || config.Height > MaxSide || config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img := &Image{format: format}
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		img.decoded = orient(decoded, jpegOrientation(data))
+		img.MimeType = "image/jpeg"
+		img.Width, img.Height = img.decoded.Bounds().Dx(), img.decoded.Bounds().Dy()
+		err = jpeg.Encode(&buf, img.decoded, &jpeg.Options{Quality: uploadJPEGQuality})
+		if err != nil {
+			return nil, err
+		}
+	case "png":
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		img.decoded = decoded
+		img.MimeType = "image/png"
+		img.Width, img.Height = config.Width, config.Height
+		if err := png.Encode(&buf, decoded); err != nil {
+			return nil, err
+		}
+	case "gif":
+		pixels, err := gifFramePixels(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		if pixels > MaxPixels {
+			return nil, ErrTooLarge
+		}
+		decoded, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		img.decoded = decoded.Image[0]
+		img.MimeType = "image/gif"
+		img.Width, img.Height = config.Width, config.Height
+		// EncodeAll writes the frames, their timing and the loop count, and no other extension.
+		if err := gif.EncodeAll(&buf, decoded); err != nil {
+			return nil, err
+		}
+	}
+	img.Data = buf.Bytes()
+	return img, nil
+}
+
+// orient turns the image upright according to an EXIF orientation: 1 is upright, 2 to 8 are the mirrored and
+// rotated variants, in the order of the EXIF specification.
+func orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the main diagonal
+				dx, dy = y, x
+			case 6: // needs a 90° clockwise rotation
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the anti-diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // needs a 90° counterclockwise rotation
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], rgba.Pix[rgba.PixOffset(x, y):rgba.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
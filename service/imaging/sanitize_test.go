@@ -0,0 +1,301 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a width x height image with a different color on every pixel.
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exifSegment returns an APP1 segment holding an EXIF orientation, as cameras write it.
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+	return jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// withSegments inserts segments right after the start of image marker of a JPEG.
+func withSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, data[2:]...)
+}
+
+// pngChunk returns a PNG chunk with its checksum.
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 4, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngHeader returns the start of a PNG claiming the dimensions, which is all DecodeConfig reads.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr, width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8-bit RGBA
+	return append([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IHDR", ihdr)...)
+}
+
+// testGIF returns a GIF with a width x height screen and frames of the given sizes, each with an empty LZW stream.
+// Only the block structure is valid, which is all the frame walker reads.
+func testGIF(width, height uint16, frames ...[2]uint16) []byte {
+	data := []byte("GIF89a")
+	data = binary.LittleEndian.AppendUint16(data, width)
+	data = binary.LittleEndian.AppendUint16(data, height)
+	data = append(data, 0, 0, 0)
+	for _, frame := range frames {
+		data = append(data, 0x2C, 0, 0, 0, 0)
+		data = binary.LittleEndian.AppendUint16(data, frame[0])
+		data = binary.LittleEndian.AppendUint16(data, frame[1])
+		data = append(data, 0, 2, 0)
+	}
+	return append(data, 0x3B)
+}
+
+func TestSanitizeRejects(t *testing.T) {
+	valid := encodePNG(t, testImage(4, 4))
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"not an image", []byte("hello, world"), ErrUnsupportedFormat},
+		{"empty", nil, ErrUnsupportedFormat},
+		{"too wide", pngHeader(MaxSide+1, 1), ErrTooLarge},
+		{"too tall", pngHeader(1, MaxSide+1), ErrTooLarge},
+		{"too many pixels", pngHeader(MaxSide/2+1, MaxPixels/(MaxSide/2)), ErrTooLarge},
+		{"frames with too many pixels", testGIF(5000, 5000, [2]uint16{5000, 5000}, [2]uint16{5000, 5000}), ErrTooLarge},
+		{"truncated", valid[:len(valid)-20], ErrInvalidImage},
+		{"header only", pngHeader(4, 4), ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Sanitize(tt.data); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSanitizeAtTheLimits(t *testing.T) {
+	img, err := Sanitize(encodePNG(t, testImage(MaxSide, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != MaxSide || img.Height != 1 {
+		t.Errorf("got %dx%d, want %dx1", img.Width, img.Height, MaxSide)
+	}
+}
+
+// jpegMarkers returns the markers of the segments of a JPEG, up to the image data.
+func jpegMarkers(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var markers []byte
+	for i := 2; i+4 <= len(data); {
+		marker := data[i+1]
+		markers = append(markers, marker)
+		if marker == 0xDA {
+			return markers
+		}
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+	}
+	t.Fatal("no image data in the JPEG")
+	return nil
+}
+
+func TestSanitizeStripsMetadata(t *testing.T) {
+	t.Run("jpeg", func(t *testing.T) {
+		data := withSegments(encodeJPEG(t, testImage(8, 8)),
+			exifSegment(binary.BigEndian, 1),
+			jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+			jpegSegment(0xFE, []byte("a comment")),
+		)
+		if markers := jpegMarkers(t, data); !bytes.Contains(markers, []byte{0xE1, 0xE1, 0xFE}) {
+			t.Fatalf("the metadata segments are missing from the upload: % x", markers)
+		}
+		img, err := Sanitize(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.MimeType != "image/jpeg" {
+			t.Errorf("got type %s", img.MimeType)
+		}
+		for _, marker := range jpegMarkers(t, img.Data) {
+			if marker >= 0xE1 && marker <= 0xEF || marker == 0xFE {
+				t.Errorf("the re-encoded JPEG has a segment %#x", marker)
+			}
+		}
+	})
+	t.Run("png", func(t *testing.T) {
+		data := encodePNG(t, testImage(8, 8))
+		// The text chunk goes right after the header chunk.
+		text := pngChunk("tEXt", []byte("Comment\x00secret"))
+		data = append(append(append([]byte{}, data[:33]...), text...), data[33:]...)
+		img, err := Sanitize(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(img.Data, []byte("tEXt")) || bytes.Contains(img.Data, []byte("secret")) {
+			t.Error("the re-encoded PNG kept the text chunk")
+		}
+	})
+	t.Run("gif", func(t *testing.T) {
+		var buf bytes.Buffer
+		palette := color.Palette{color.Black, color.White}
+		frames := &gif.GIF{
+			Image: []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4, 4), palette), image.NewPaletted(image.Rect(0, 0, 4, 4), palette)},
+			Delay: []int{10, 10},
+		}
+		if err := gif.EncodeAll(&buf, frames); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		// A comment extension goes before the trailer.
+		comment := append([]byte{0x21, 0xFE, 6}, "secret\x00"...)
+		data = append(append(append([]byte{}, data[:len(data)-1]...), comment...), 0x3B)
+		img, err := Sanitize(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(img.Data, []byte("secret")) {
+			t.Error("the re-encoded GIF kept the comment")
+		}
+		decoded, err := gif.DecodeAll(bytes.NewReader(img.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded.Image) != 2 {
+			t.Errorf("got %d frames, want 2", len(decoded.Image))
+		}
+	})
+}
+
+func TestSanitizeAppliesOrientation(t *testing.T) {
+	tests := []struct {
+		orientation           uint16
+		order                 binary.ByteOrder
+		wantWidth, wantHeight int
+	}{
+		{1, binary.BigEndian, 32, 16},
+		{3, binary.LittleEndian, 32, 16},
+		{6, binary.BigEndian, 16, 32},
+		{8, binary.LittleEndian, 16, 32},
+	}
+	for _, tt := range tests {
+		data := withSegments(encodeJPEG(t, testImage(32, 16)), exifSegment(tt.order, tt.orientation))
+		img, err := Sanitize(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Width != tt.wantWidth || img.Height != tt.wantHeight {
+			t.Errorf("orientation %d: got %dx%d, want %dx%d", tt.orientation, img.Width, img.Height, tt.wantWidth, tt.wantHeight)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(img.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != img.Width || config.Height != img.Height {
+			t.Errorf("orientation %d: encoded %dx%d, reported %dx%d", tt.orientation, config.Width, config.Height, img.Width, img.Height)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// The source is a 3x2 image, with pixels a to f:
+	//   a b c
+	//   d e f
+	const a, b, c, d, e, f = 10, 20, 30, 40, 50, 60
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{0, [][]uint8{{a, b, c}, {d, e, f}}},
+		{1, [][]uint8{{a, b, c}, {d, e, f}}},
+		{2, [][]uint8{{c, b, a}, {f, e, d}}},
+		{3, [][]uint8{{f, e, d}, {c, b, a}}},
+		{4, [][]uint8{{d, e, f}, {a, b, c}}},
+		{5, [][]uint8{{a, d}, {b, e}, {c, f}}},
+		{6, [][]uint8{{d, a}, {e, b}, {f, c}}},
+		{7, [][]uint8{{f, c}, {e, b}, {d, a}}},
+		{8, [][]uint8{{c, f}, {b, e}, {a, d}}},
+		{9, [][]uint8{{a, b, c}, {d, e, f}}},
+	}
+	// The source does not start at the origin, as sub-images do not.
+	src := image.NewRGBA(image.Rect(5, 5, 8, 7))
+	for i, v := range []uint8{a, b, c, d, e, f} {
+		src.Set(5+i%3, 5+i/3, color.RGBA{R: v, A: 255})
+	}
+	for _, tt := range tests {
+		got := orient(src, tt.orientation)
+		bounds := got.Bounds()
+		if bounds.Dx() != len(tt.want[0]) || bounds.Dy() != len(tt.want) {
+			t.Errorf("orientation %d: got %dx%d, want %dx%d", tt.orientation, bounds.Dx(), bounds.Dy(), len(tt.want[0]), len(tt.want))
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				r, _, _, _ := got.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				if uint8(r>>8) != want {
+					t.Errorf("orientation %d: pixel (%d, %d) is %d, want %d", tt.orientation, x, y, r>>8, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package imaging builds the downscaled variants of uploaded images with the standard library codecs.
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	_ "image/gif" // register the GIF decoder for image.Decode
+	"image/jpeg"
+	"image/png"
+)
+
+// Size is a fixed thumbnail size, the longest side of the variant in pixels.
+type Size struct {
+	Name    string
+	MaxSide int
+}
+
+// The thumbnail sizes built for uploaded images. Small is meant for avatars and listings, medium for previews in a
+// chat.
+var (
+	Small  = Size{Name: "small", MaxSide: 160}
+	Medium = Size{Name: "medium", MaxSide: 640}
+)
+
+// Sizes lists the thumbnail sizes, smallest first.
+var Sizes = []Size{Small, Medium}
+
+// jpegQuality is the quality of JPEG thumbnails.
+const jpegQuality = 85
+
+// Thumbnail is an encoded, downscaled image.
+type Thumbnail struct {
+	Size     Size
+	Data     []byte
+	MimeType string
+	Width    int
+	Height   int
+}
+
+// SizeByName returns the thumbnail size with the given name.
+func SizeByName(name string) (Size, bool) {
+	for _, size := range Sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return Size{}, false
+}
+
+// Thumbnails scales the image down to each of the sizes, keeping its aspect ratio. Sizes the image already fits are
+// skipped, since the original serves for them. JPEG images give JPEG thumbnails; the others give PNG thumbnails, which
+// keep transparency. Thumbnails of animated GIFs show the first frame.
+func (img *Image) Thumbnails(sizes ...Size) ([]Thumbnail, error) {
+	src := img.decoded
+	bounds := src.Bounds()
+	var thumbs []Thumbnail
+	var rgba *image.RGBA
+	for _, size := range sizes {
+		width, height := fit(bounds.Dx(), bounds.Dy(), size.MaxSide)
+		if width == bounds.Dx() && height == bounds.Dy() {
+			continue
+		}
+		if rgba == nil {
+			rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+			draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+		}
+		dst := resize(rgba, width, height)
+
+		var buf bytes.Buffer
+		thumb := Thumbnail{Size: size, Width: width, Height: height}
+		var err error
+		if img.format == "jpeg" {
+			thumb.MimeType = "image/jpeg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			thumb.MimeType = "image/png"
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumb.Data = buf.Bytes()
+		thumbs = append(thumbs, thumb)
+	}
+	return thumbs, nil
+}
+
+// fit returns the dimensions of a width x height image scaled down so that its longest side is at most maxSide.
+// Images that already fit are left alone.
+func fit(width, height, maxSide int) (int, int) {
+	if width <= maxSide && height <= maxSide {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max1(height * maxSide / width)
+	}
+	return max1(width * maxSide / height), maxSide
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// resize scales the image down with a box filter: every destination pixel is the average of the source pixels it
+// covers. Colors are averaged premultiplied, so transparent pixels do not bleed into their neighbours.
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for dy := 0; dy < height; dy++ {
+		y0, y1 := dy*srcH/height, (dy+1)*srcH/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for dx := 0; dx < width; dx++ {
+			x0, x1 := dx*srcW/width, (dx+1)*srcW/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,219 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height, maxSide int
+		wantWidth, wantHeight  int
+	}{
+		{160, 80, 160, 160, 80},
+		{100, 100, 160, 100, 100},
+		{161, 80, 160, 160, 79},
+		{80, 161, 160, 79, 160},
+		{320, 320, 160, 160, 160},
+		{1000, 750, 640, 640, 480},
+		{10000, 1, 160, 160, 1},
+		{1, 10000, 160, 1, 160},
+	}
+	for _, tt := range tests {
+		width, height := fit(tt.width, tt.height, tt.maxSide)
+		if width != tt.wantWidth || height != tt.wantHeight {
+			t.Errorf("%dx%d in %d: got %dx%d, want %dx%d",
+				tt.width, tt.height, tt.maxSide, width, height, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
+
+func TestThumbnails(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		// want lists the expected width and height of the small and medium thumbnails; a zero size is skipped.
+		want [2][2]int
+	}{
+		{"smaller than all sizes", 100, 50, [2][2]int{}},
+		{"at the small size", 160, 160, [2][2]int{}},
+		{"just over the small size", 161, 100, [2][2]int{{160, 99}}},
+		{"landscape", 1280, 720, [2][2]int{{160, 90}, {640, 360}}},
+		{"portrait", 720, 1280, [2][2]int{{90, 160}, {360, 640}}},
+		{"at the medium size", 640, 480, [2][2]int{{160, 120}}},
+		{"one pixel high", 2000, 1, [2][2]int{{160, 1}, {640, 1}}},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{"jpeg", "png"} {
+			t.Run(tt.name+" "+format, func(t *testing.T) {
+				data := encodePNG(t, testImage(tt.width, tt.height))
+				if format == "jpeg" {
+					data = encodeJPEG(t, testImage(tt.width, tt.height))
+				}
+				img, err := Sanitize(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				thumbs, err := img.Thumbnails(Sizes...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var want []Thumbnail
+				for i, size := range tt.want {
+					if size[0] != 0 {
+						want = append(want, Thumbnail{Size: Sizes[i], Width: size[0], Height: size[1]})
+					}
+				}
+				if len(thumbs) != len(want) {
+					t.Fatalf("got %d thumbnails, want %d", len(thumbs), len(want))
+				}
+				for i, thumb := range thumbs {
+					if thumb.Size != want[i].Size || thumb.Width != want[i].Width || thumb.Height != want[i].Height {
+						t.Errorf("got %s %dx%d, want %s %dx%d", thumb.Size.Name, thumb.Width, thumb.Height,
+							want[i].Size.Name, want[i].Width, want[i].Height)
+					}
+					if thumb.MimeType != "image/"+format {
+						t.Errorf("got type %s for a %s image", thumb.MimeType, format)
+					}
+					config, decodedFormat, err := image.DecodeConfig(bytes.NewReader(thumb.Data))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if decodedFormat != format || config.Width != thumb.Width || config.Height != thumb.Height {
+						t.Errorf("encoded a %s %dx%d, reported %dx%d",
+							decodedFormat, config.Width, config.Height, thumb.Width, thumb.Height)
+					}
+					// The aspect ratio is kept, within a pixel of rounding of the shortest side.
+					exact := float64(tt.height) * float64(thumb.Width) / float64(tt.width)
+					if got := float64(thumb.Height); got > exact+1 || got < exact-1 && got > 1 {
+						t.Errorf("%dx%d does not keep the aspect ratio of %dx%d", thumb.Width, thumb.Height, tt.width, tt.height)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	// Each 2x2 block of the source becomes one pixel, the average of the block.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	src.SetRGBA(0, 0, color.RGBA{R: 100, A: 255})
+	src.SetRGBA(1, 0, color.RGBA{R: 200, A: 255})
+	src.SetRGBA(0, 1, color.RGBA{G: 40, A: 255})
+	src.SetRGBA(1, 1, color.RGBA{G: 80, A: 255})
+	// Transparent pixels weigh in as transparent black, so no color bleeds from them.
+	src.SetRGBA(2, 0, color.RGBA{R: 255, A: 255})
+	dst := resize(src, 2, 1)
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{R: 75, G: 30, A: 255}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(1, 0), (color.RGBA{R: 63, A: 63}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResizeUneven(t *testing.T) {
+	// Scaling 3 pixels to 2 or 5 to 3 must cover every destination pixel.
+	for _, size := range [][4]int{{3, 3, 2, 2}, {5, 1, 3, 1}, {1, 7, 1, 3}, {2, 2, 2, 2}} {
+		src := image.NewRGBA(image.Rect(0, 0, size[0], size[1]))
+		for i := range src.Pix {
+			src.Pix[i] = 255
+		}
+		dst := resize(src, size[2], size[3])
+		if dst.Bounds().Dx() != size[2] || dst.Bounds().Dy() != size[3] {
+			t.Fatalf("got %v, want %dx%d", dst.Bounds(), size[2], size[3])
+		}
+		for i, v := range dst.Pix {
+			if v != 255 {
+				t.Fatalf("%dx%d to %dx%d: byte %d is %d, want 255", size[0], size[1], size[2], size[3], i, v)
+			}
+		}
+	}
+}
+
+// animatedGIF encodes a GIF with frames of the given sizes. Each frame has its own palette, so all but the first carry
+// a local color table, and the frame delays and loop count add extension blocks.
+func animatedGIF(t *testing.T, sizes ...[2]int) []byte {
+	t.Helper()
+	animation := &gif.GIF{LoopCount: 0}
+	for i, size := range sizes {
+		palette := color.Palette{color.Black, color.RGBA{R: uint8(i * 40), A: 255}}
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, size[0], size[1]), palette))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	animation.Config = image.Config{Width: 20, Height: 20}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFramePixels(t *testing.T) {
+	animated := animatedGIF(t, [2]int{20, 20}, [2]int{10, 5}, [2]int{3, 7})
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"one frame", testGIF(10, 10, [2]uint16{10, 10}), 100},
+		{"no frame", testGIF(10, 10), 0},
+		{"frames of several sizes", testGIF(10, 10, [2]uint16{10, 10}, [2]uint16{4, 5}, [2]uint16{1, 1}), 121},
+		{"color tables and extensions", animated, 20*20 + 10*5 + 3*7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gifFramePixels(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d pixels, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGIFFramePixelsStopsOverTheLimit(t *testing.T) {
+	// The second frame brings the total over the limit: the walker stops there, and never sees the corrupt trailer.
+	data := testGIF(5000, 5000, [2]uint16{5000, 5000}, [2]uint16{5000, 5000})
+	data[len(data)-1] = 0x99
+	got, err := gifFramePixels(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got <= MaxPixels {
+		t.Errorf("got %d pixels, want more than %d", got, MaxPixels)
+	}
+}
+
+func TestGIFFramePixelsCorrupt(t *testing.T) {
+	valid := animatedGIF(t, [2]int{20, 20}, [2]int{10, 5})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"header only", valid[:13]},
+		{"unknown block", append(append([]byte{}, valid[:len(valid)-1]...), 0x99)},
+		{"no trailer", valid[:len(valid)-1]},
+		{"global color table past the end", append([]byte("GIF89a\x01\x00\x01\x00\x87\x00\x00"), 0x3B)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := gifFramePixels(tt.data); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+	// Cut anywhere, the walker reports the truncation rather than reading past the end.
+	for n := 0; n < len(valid); n++ {
+		if _, err := gifFramePixels(valid[:n]); !errors.Is(err, errTruncated) {
+			t.Errorf("%d of %d bytes: got error %v, want %v", n, len(valid), err, errTruncated)
+		}
+	}
+}
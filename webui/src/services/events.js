@@ -0,0 +1,29 @@
+// subscribeEvents opens the event stream of the logged-in user and calls onEvent with every conversation change the
+// server pushes. The browser reconnects on its own after network errors. It returns a function closing the stream.
+const eventTypes = [
+	"conversation_created",
+	"conversation_updated",
+	"message_created",
+	"message_deleted",
+	"message_edited",
+	"message_hidden",
+	"reaction_added",
+	"reaction_removed",
+	"comment_added",
+	"comment_edited",
+	"comment_deleted",
+	"messages_delivered",
+	"messages_read",
+];
+
+export function subscribeEvents(onEvent) {
+	const token = localStorage.getItem("token");
+	if (!token) {
+		return () => {};
+	}
+	const source = new EventSource(`${__API_URL__}/events?token=${encodeURIComponent(token)}`);
+	for (const type of eventTypes) {
+		source.addEventListener(type, (e) => onEvent(JSON.parse(e.data)));
+	}
+	return () => source.close();
+}
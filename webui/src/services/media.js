@@ -0,0 +1,35 @@
+// mediaUrl returns the address of a stored attachment, usable as the src of an <img>. Such requests cannot carry the
+// Authorization header, so the session token goes in the query. With a variant ("small" or "medium"), the address is
+// the one of a thumbnail, which falls back to the original for images that are already small.
+export function mediaUrl(media, variant) {
+	const token = localStorage.getItem("token") || "";
+	const path = variant ? `${media.id}/${variant}` : media.id;
+	return `${__API_URL__}/media/${path}?token=${encodeURIComponent(token)}`;
+}
+
+// downloadUrl returns the address that saves an attachment under its original name instead of opening it.
+export function downloadUrl(media) {
+	return `${mediaUrl(media)}&download=true`;
+}
+
+// isImage reports whether an attachment is an image, and so has thumbnails.
+export function isImage(media) {
+	return media.mimeType.startsWith("image/");
+}
+
+// formatSize returns a file size for display, in bytes, KB or MB.
+export function formatSize(size) {
+	if (size < 1024) {
+		return `${size} B`;
+	}
+	if (size < 1024 * 1024) {
+		return `${Math.round(size / 1024)} KB`;
+	}
+	return `${(size / (1024 * 1024)).toFixed(1)} MB`;
+}
+
+// conversationPhotoUrl returns the address of the full size photo of a conversation. Listings only carry a thumbnail.
+export function conversationPhotoUrl(conversationId) {
+	const token = localStorage.getItem("token") || "";
+	return `${__API_URL__}/conversations/${conversationId}/photo?token=${encodeURIComponent(token)}`;
+}